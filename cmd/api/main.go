@@ -2,41 +2,101 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/handlers"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/config"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/workers"
 )
 
+const migrateTimeout = 5 * time.Minute
+
 func main() {
 	cfg := config.LoadConfig()
-	db := database.LoadConnections(cfg.DbConnCfg)
-	paymentRep := repositories.NewPaymentRepository(db)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
+	db, paymentStore, degraded, closeStore, err := loadStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	acceptedLog := loadWAL(cfg)
+
+	aggregates, aggregatesLog := loadAggregates(cfg)
+	if aggregates != nil {
+		paymentStore = repositories.NewAggregatingPaymentStore(paymentStore, aggregates, service.NewPeerService(cfg))
+	}
+
 	procService := service.NewProcessorService(cfg)
-	healthCheckerWorker := workers.NewHealthChecker(db, procService)
-	dlq := workers.NewDQL(healthCheckerWorker, procService, paymentRep)
+	reconService := service.NewReconciliationService(procService, paymentStore)
+	elector := workers.NewLeaderElector(db, cfg.Hostname)
+	healthCheckerWorker := workers.NewHealthChecker(db, procService, elector)
+	scorer := workers.NewHealthScorer(healthCheckerWorker)
+	dlq := workers.NewDQL(healthCheckerWorker, scorer, procService, paymentStore, acceptedLog)
+	reconciler := workers.NewReconciler(cfg, db, reconService, elector)
 	mux := http.NewServeMux()
 
 	slog.SetLogLoggerLevel(slog.Level(cfg.LogLevel))
 
 	dlq.StartDQLWorker()
+	elector.StartLeaderElection()
+	healthCheckerWorker.StartHealthChecker()
+	reconciler.StartReconciler()
+
+	var outbox repositories.PaymentOutbox
+	var dispatcher *workers.OutboxDispatcher
+	if db != nil {
+		paymentRep := repositories.NewPaymentRepository(db)
+
+		db.Listen(workers.PurgeChannel, dlq.HandlePurgeNotification)
+		workers.NewPartitionManager(cfg, paymentRep, elector).StartPartitionManager()
 
-	if cfg.StartHealthChecker {
-		healthCheckerWorker.StartHealthChecker()
+		if cfg.OutboxEnabled {
+			outbox = paymentRep
+			dispatcher = workers.NewOutboxDispatcher(paymentRep, procService, scorer, aggregates)
+			dispatcher.StartOutboxDispatcher()
+		}
 	}
 
-	mux.Handle("POST /payments", handlers.NewPaymentCreateHandler(paymentRep, procService, dlq).Handle())
-	mux.Handle("GET /payments-summary", handlers.NewPaymentGetSummaryHandler(db).Handle())
-	mux.Handle("POST /purge-payments", handlers.NewPaymentsPurgeHandler(db, procService).Handle())
+	if acceptedLog != nil {
+		workers.ReplayWAL(acceptedLog, paymentStore, procService, dlq, outbox)
+	}
+
+	mux.Handle("POST /payments", handlers.NewPaymentCreateHandler(paymentStore, procService, scorer, dlq, outbox, acceptedLog).Handle())
+	mux.Handle("GET /payments", handlers.NewPaymentSearchHandler(paymentStore).Handle())
+	mux.Handle("GET /payments/{correlationId}", handlers.NewPaymentGetHandler(paymentStore).Handle())
+	mux.Handle("GET /payments/export", handlers.NewPaymentExportHandler(paymentStore).Handle())
+	mux.Handle("GET /payments-summary", handlers.NewPaymentGetSummaryHandler(paymentStore, procService, cfg.SummarySettleTimeout).Handle())
+	mux.Handle("GET /payments-summary/timeseries", handlers.NewPaymentSummaryTimeseriesHandler(paymentStore).Handle())
+	mux.Handle("POST /purge-payments", handlers.NewPaymentsPurgeHandler(db, procService, paymentStore, healthCheckerWorker, dlq, cfg.AdminToken).Handle())
+	mux.Handle("GET /status", handlers.NewStatusHandler(elector).Handle())
+	mux.Handle("GET /ready", handlers.NewReadinessHandler(degraded).Handle())
+	mux.Handle("GET /reconciliation", handlers.NewReconciliationHandler(reconService).Handle())
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.Handle("GET /processors/health", handlers.NewProcessorsHealthHandler(healthCheckerWorker).Handle())
+
+	if aggregates != nil {
+		mux.Handle("GET "+service.PeerAggregatesPath, handlers.NewPeerAggregatesHandler(aggregates, cfg.AggregationPeerToken).Handle())
+		mux.Handle("POST "+service.PeerAggregatesPurgePath, handlers.NewPeerAggregatesPurgeHandler(aggregates, cfg.AggregationPeerToken).Handle())
+	}
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%v", cfg.Port),
@@ -48,13 +108,195 @@ func main() {
 		server.ListenAndServe()
 	}()
 
-	shutdownServer(server)
+	servers := []*http.Server{server}
+	if cfg.AggregationSocket != "" {
+		servers = append(servers, serveUnixSocket(cfg.AggregationSocket, mux))
+	}
+
+	shutdownServers(servers...)
+
+	// nothing may write to the store once it is closed
+	dlq.Stop()
+	if dispatcher != nil {
+		dispatcher.Stop()
+	}
+
+	elector.Resign()
+	closeStore()
+
+	if acceptedLog != nil {
+		err := acceptedLog.Close()
+		if err != nil {
+			slog.Error("Error closing wal", "err", err)
+		}
+	}
+
+	if aggregatesLog != nil {
+		err := aggregatesLog.Close()
+		if err != nil {
+			slog.Error("Error closing aggregates wal", "err", err)
+		}
+	}
 
-	db.Conn.Close()
 	slog.Info("bye")
 }
 
-func shutdownServer(server *http.Server) {
+// loadStore returns a nil db when payments are kept in memory, and a function flushing the store and closing the database
+func loadStore(cfg *config.Config) (*database.Db, repositories.PaymentStore, *repositories.DegradedPaymentStore, func(), error) {
+	if cfg.Store == config.StoreMemory {
+		slog.Warn("payments are kept in memory only, they are lost on restart")
+		return nil, repositories.NewMemoryPaymentStore(), nil, func() {}, nil
+	}
+
+	if cfg.DegradedLogDir != "" && !filepath.IsAbs(cfg.DegradedLogDir) {
+		return nil, nil, nil, nil, fmt.Errorf("degraded log dir %q must be an absolute path", cfg.DegradedLogDir)
+	}
+
+	db, err := database.LoadConnections(cfg.DbConnCfg)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	db.StartReplicaMonitor()
+
+	paymentRep := repositories.NewPaymentRepository(db)
+	var store repositories.PaymentStore = paymentRep
+
+	var writeBehind *repositories.WriteBehindPaymentStore
+	if cfg.WriteBehindSize > 0 && cfg.WriteBehindInterval > 0 {
+		writeBehind = repositories.NewWriteBehindPaymentStore(paymentRep, cfg.WriteBehindSize, cfg.WriteBehindInterval)
+		writeBehind.StartWriteBehind()
+		store = writeBehind
+	}
+
+	closeStore := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if writeBehind != nil {
+			err := writeBehind.Close(ctx)
+			if err != nil {
+				slog.Error("Error flushing buffered payment writes on shutdown", "err", err)
+			}
+		}
+
+		db.Close()
+	}
+
+	if cfg.DegradedLogDir == "" {
+		return db, store, nil, closeStore, nil
+	}
+
+	degradedLog, err := wal.Open(cfg.DegradedLogDir, cfg.WalSegmentMaxBytes, cfg.WalSyncInterval)
+	if err != nil {
+		closeStore()
+		return nil, nil, nil, nil, fmt.Errorf("opening degraded write log: %w", err)
+	}
+
+	degraded := repositories.NewDegradedPaymentStore(store, cfg.DegradedLogMaxEntries, migratedProbe(db), degradedLog)
+	degraded.StartMonitor()
+
+	closeDegraded := func() {
+		closeStore()
+
+		if status := degraded.Status(); status.BufferedWrites > 0 {
+			slog.Warn("shutting down with logged payment writes not replayed, they are replayed on the next start", "count", status.BufferedWrites)
+		}
+
+		err := degradedLog.Close()
+		if err != nil {
+			slog.Error("Error closing degraded write log", "err", err)
+		}
+	}
+
+	return db, degraded, degraded, closeDegraded, nil
+}
+
+// loadWAL returns nil when no WAL directory is configured
+func loadWAL(cfg *config.Config) *wal.WAL {
+	if cfg.WalDir == "" {
+		return nil
+	}
+
+	acceptedLog, err := wal.Open(cfg.WalDir, cfg.WalSegmentMaxBytes, cfg.WalSyncInterval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "opening wal:", err)
+		os.Exit(1)
+	}
+
+	return acceptedLog
+}
+
+// loadAggregates returns nil when aggregation is disabled
+func loadAggregates(cfg *config.Config) (*repositories.PaymentAggregates, *wal.WAL) {
+	if !cfg.AggregationEnabled {
+		return nil, nil
+	}
+
+	aggregatesLog, err := wal.Open(cfg.AggregationDir, cfg.WalSegmentMaxBytes, cfg.WalSyncInterval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "opening aggregates wal:", err)
+		os.Exit(1)
+	}
+
+	return repositories.NewPaymentAggregates(aggregatesLog, cfg.PaymentsRetention), aggregatesLog
+}
+
+// migratedProbe reports the database as available once it answers and every migration was applied
+func migratedProbe(db *database.Db) func(ctx context.Context) error {
+	var migrated atomic.Bool
+
+	return func(ctx context.Context) error {
+		if !migrated.Load() {
+			// migrations may take longer than a probe is given
+			migrateCtx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+			defer cancel()
+
+			applied, err := db.MigrateUp(migrateCtx)
+			if err != nil {
+				return fmt.Errorf("migrating database: %w", err)
+			}
+
+			migrated.Store(true)
+			slog.Info("database migrated", "applied", applied)
+		}
+
+		return db.Conn.Ping(ctx)
+	}
+}
+
+// serveUnixSocket serves the same routes on a unix socket, for peers on the same host
+func serveUnixSocket(path string, handler http.Handler) *http.Server {
+	// a socket left behind by a previous run would fail the listen
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// peers may run as other users, in other containers sharing the socket directory
+	err = os.Chmod(path, 0o666)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		slog.Info("server started", "socket", path)
+		server.Serve(listener)
+	}()
+
+	return server
+}
+
+func shutdownServers(servers ...*http.Server) {
 	slog.Info("listening for shutdown signals")
 	rootCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -64,5 +306,7 @@ func shutdownServer(server *http.Server) {
 	defer cancel()
 
 	slog.Info("server shutting down")
-	server.Shutdown(shutdownCtx)
+	for _, server := range servers {
+		server.Shutdown(shutdownCtx)
+	}
 }
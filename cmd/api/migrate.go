@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/config"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+)
+
+const migrateUsage = "usage: main migrate up | down [steps] | status"
+
+// runMigrate runs the migrate subcommand and returns the process exit code. down reverts a single migration unless told otherwise
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.LoadConnections(cfg.DbConnCfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to migrate database: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+
+		reverted, err := db.MigrateDown(ctx, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to revert migrations: %v\n", err)
+			return 1
+		}
+
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read migrations: %v\n", err)
+			return 1
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	}
+
+	return 0
+}
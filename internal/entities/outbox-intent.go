@@ -0,0 +1,8 @@
+package entities
+
+// OutboxIntent is a payment waiting to be forwarded to a processor by the outbox dispatcher
+type OutboxIntent struct {
+	Id       int64
+	P        *Payment
+	Attempts int
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+// PaymentFilter fields left empty don't filter anything
+type PaymentFilter struct {
+	TimeRange
+	Processor      string
+	Status         string
+	MinAmountCents *int64
+	MaxAmountCents *int64
+}
+
+// PaymentCursor points at the last payment of a page, in (requested_at, id) order
+type PaymentCursor struct {
+	RequestedAt time.Time
+	Id          int64
+}
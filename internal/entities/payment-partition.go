@@ -0,0 +1,10 @@
+package entities
+
+import "time"
+
+// PaymentPartition is a daily partition of the payments table, holding payments requested in [From, To)
+type PaymentPartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
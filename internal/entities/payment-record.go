@@ -0,0 +1,17 @@
+package entities
+
+import "time"
+
+// PaymentRecord is a payment as stored, with what we know about how it was processed
+type PaymentRecord struct {
+	Id            int64
+	CorrelationId string
+	AmountCents   int64
+	Processor     *string
+	Status        string
+	RequestedAt   time.Time
+}
+
+func (p PaymentRecord) Amount() float64 {
+	return float64(p.AmountCents) / 100
+}
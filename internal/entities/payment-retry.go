@@ -5,4 +5,8 @@ type PaymentRetry struct {
 	FailureCount      int
 	LastProcessorUsed string
 	LastFailureReason string
+	// WAL entry of the payment, zero when the WAL is disabled
+	WalSeq uint64
+	// purges of a later generation than the retry apply to it, set when first queued
+	PurgeGeneration uint64
 }
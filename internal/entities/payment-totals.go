@@ -0,0 +1,17 @@
+package entities
+
+import "time"
+
+type PaymentTotals struct {
+	TotalRequests int64
+	AmountCents   int64
+}
+
+func (t PaymentTotals) TotalAmount() float64 {
+	return float64(t.AmountCents) / 100
+}
+
+type PaymentBucket struct {
+	Start      time.Time
+	Processors map[string]PaymentTotals
+}
@@ -2,6 +2,12 @@ package entities
 
 import "time"
 
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusProcessed = "processed"
+	PaymentStatusParked    = "parked"
+)
+
 type Payment struct {
 	CorrelationId string    `json:"correlationId"`
 	Amount        float64   `json:"amount"`
@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+// TimeRange bounds are inclusive and in UTC with millisecond precision, a nil bound leaves that side open
+type TimeRange struct {
+	From *time.Time
+	To   *time.Time
+}
+
+func (t TimeRange) IsOpen() bool {
+	return t.From == nil && t.To == nil
+}
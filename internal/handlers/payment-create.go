@@ -2,25 +2,34 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/workers"
 )
 
 type PaymentCreateHandler struct {
-	paymentRep  *repositories.PaymentRepository
-	procService *service.ProcessorService
-	dlq         *workers.DLQ
+	paymentStore repositories.PaymentStore
+	procService  *service.ProcessorService
+	scorer       *workers.HealthScorer
+	dlq          *workers.DLQ
+
+	// when set, payments are only recorded along with their forwarding intent and the outbox dispatcher forwards them
+	outbox repositories.PaymentOutbox
+	// when set, payments are written to it before anything else and acknowledged once settled, so a crash can't lose them
+	wal *wal.WAL
 }
 
-func NewPaymentCreateHandler(paymentRep *repositories.PaymentRepository, procService *service.ProcessorService, dlq *workers.DLQ) *PaymentCreateHandler {
-	return &PaymentCreateHandler{paymentRep, procService, dlq}
+func NewPaymentCreateHandler(paymentStore repositories.PaymentStore, procService *service.ProcessorService, scorer *workers.HealthScorer, dlq *workers.DLQ, outbox repositories.PaymentOutbox, acceptedLog *wal.WAL) *PaymentCreateHandler {
+	return &PaymentCreateHandler{paymentStore, procService, scorer, dlq, outbox, acceptedLog}
 }
 
 func (p *PaymentCreateHandler) Handle() http.HandlerFunc {
@@ -37,45 +46,93 @@ func (p *PaymentCreateHandler) Handle() http.HandlerFunc {
 		}
 
 		logger := slog.With("correlationId", payment.CorrelationId)
-
 		paymentJSONBytes, _ := json.Marshal(&payment)
 
-		// rep, err := p.paymentRep.Start()
-		// if err != nil {
-		// 	w.WriteHeader(http.StatusInternalServerError)
-		// 	return
-		// }
+		var walSeq uint64
+		if p.wal != nil {
+			walSeq, err = p.wal.Append(paymentJSONBytes)
+			if err != nil {
+				logger.Error("Error writing payment to the wal", "err", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		// without the database the outbox can't take the payment, so it is forwarded right away like when there is no outbox
+		if p.outbox != nil {
+			err = p.outbox.EnqueueForwarding(r.Context(), &payment)
+			if err == nil {
+				p.ack(logger, walSeq)
 
-		// _, err = rep.Create(&payment, processorUsed)
-		// if err != nil {
-		// 	logger.Error("Error inserting to database:", "err", err)
-		// 	w.WriteHeader(http.StatusInternalServerError)
-		// 	return
-		// }
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+
+			logger.Error("Error recording payment in the outbox, forwarding it directly", "err", err)
+		}
+
+		// don't spend the request timeout on a processor we already know is unhealthy, the DLQ will route it once one recovers
+		if !p.scorer.IsHealthy(service.ProcessorDefault) {
+			p.deferToDLQ(r.Context(), &entities.PaymentRetry{
+				P:                 &payment,
+				LastFailureReason: workers.FailureProcUnhealthy,
+				WalSeq:            walSeq,
+			})
+
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
 
+		start := time.Now()
 		_, resStatus, err := p.procService.MakeRequestDefault(http.MethodPost, "/payments", bytes.NewReader(paymentJSONBytes), 0)
+		p.scorer.RecordForward(service.ProcessorDefault, time.Since(start), resStatus, err)
+
 		if err != nil || resStatus > 399 {
 			logger.Error("Error calling default processor, sending to DQL", "err", err, "resStatus", resStatus)
-			failureReason := workers.FailureProcError
-			if resStatus >= 399 {
-				failureReason = workers.FailureProcTimeout
-			}
-
-			p.dlq.PushToQueue(&entities.PaymentRetry{
+			p.deferToDLQ(r.Context(), &entities.PaymentRetry{
 				P:                 &payment,
 				FailureCount:      1,
 				LastProcessorUsed: service.ProcessorDefault,
-				LastFailureReason: failureReason,
+				LastFailureReason: workers.FailureReason(err),
+				WalSeq:            walSeq,
 			})
 
-			// rep.Cancel()
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			return
 		}
 
-		p.paymentRep.Create(&payment, processorUsed)
-
-		// rep.Finish()
+		// acknowledged once durable, and left in the wal on failure for the replay to find it in the processor
+		ctx := repositories.WithOnDurable(r.Context(), func() { p.ack(logger, walSeq) })
+		err = p.paymentStore.MarkProcessed(ctx, &payment, processorUsed)
+		if err != nil {
+			logger.Error("Error recording processed payment", "err", err)
+		}
 
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 	})
 }
+
+// deferToDLQ records the payment as pending before queueing it, so every instance can tell it is not settled yet
+func (p *PaymentCreateHandler) deferToDLQ(ctx context.Context, pr *entities.PaymentRetry) {
+	err := p.paymentStore.CreatePending(ctx, pr.P)
+	if err != nil {
+		slog.Error("Error recording pending payment", "correlationId", pr.P.CorrelationId, "err", err)
+	}
+
+	p.dlq.PushToQueue(pr)
+}
+
+func (p *PaymentCreateHandler) ack(logger *slog.Logger, walSeq uint64) {
+	if p.wal == nil {
+		return
+	}
+
+	err := p.wal.Ack(walSeq)
+	if err != nil && !errors.Is(err, wal.ErrClosed) {
+		logger.Error("Error acknowledging wal entry", "seq", walSeq, "err", err)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	exportFlushEvery = 1000
+)
+
+var exportCSVHeader = []string{"id", "correlation_id", "processor", "status", "amount", "requested_at"}
+
+type PaymentExportHandler struct {
+	paymentStore repositories.PaymentStore
+}
+
+type PaymentRecordOutput struct {
+	Id            int64     `json:"id"`
+	CorrelationId string    `json:"correlationId"`
+	Processor     *string   `json:"processor"`
+	Status        string    `json:"status"`
+	Amount        float64   `json:"amount"`
+	RequestedAt   time.Time `json:"requestedAt"`
+}
+
+func NewPaymentExportHandler(paymentStore repositories.PaymentStore) *PaymentExportHandler {
+	return &PaymentExportHandler{paymentStore}
+}
+
+func (p *PaymentExportHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = exportFormatCSV
+		}
+
+		if format != exportFormatCSV && format != exportFormatNDJSON {
+			http.Error(w, "format must be csv or ndjson", http.StatusBadRequest)
+			return
+		}
+
+		tr, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		written := 0
+
+		var write func(*entities.PaymentRecord) error
+		flushFormat := func() {}
+
+		if format == exportFormatCSV {
+			w.Header().Add("Content-Type", "text/csv")
+			w.Header().Add("Content-Disposition", `attachment; filename="payments.csv"`)
+
+			csvWriter := csv.NewWriter(w)
+			defer csvWriter.Flush()
+			flushFormat = csvWriter.Flush
+
+			csvWriter.Write(exportCSVHeader)
+			write = func(record *entities.PaymentRecord) error {
+				processor := ""
+				if record.Processor != nil {
+					processor = *record.Processor
+				}
+
+				return csvWriter.Write([]string{
+					strconv.FormatInt(record.Id, 10),
+					record.CorrelationId,
+					processor,
+					record.Status,
+					strconv.FormatFloat(record.Amount(), 'f', 2, 64),
+					record.RequestedAt.Format(time.RFC3339Nano),
+				})
+			}
+		} else {
+			w.Header().Add("Content-Type", "application/x-ndjson")
+
+			encoder := json.NewEncoder(w)
+			write = func(record *entities.PaymentRecord) error {
+				return encoder.Encode(newPaymentRecordOutput(record))
+			}
+		}
+
+		err = p.paymentStore.Export(r.Context(), tr, func(record *entities.PaymentRecord) error {
+			err := write(record)
+			if err != nil {
+				return err
+			}
+
+			written++
+			if flusher != nil && written%exportFlushEvery == 0 {
+				flushFormat()
+				flusher.Flush()
+			}
+
+			return nil
+		})
+		// the status line is already out, all we can do is cut the stream short
+		if err != nil {
+			slog.Error("Error exporting payments", "err", err, "written", written)
+		}
+	})
+}
+
+func newPaymentRecordOutput(record *entities.PaymentRecord) *PaymentRecordOutput {
+	return &PaymentRecordOutput{
+		Id:            record.Id,
+		CorrelationId: record.CorrelationId,
+		Processor:     record.Processor,
+		Status:        record.Status,
+		Amount:        record.Amount(),
+		RequestedAt:   record.RequestedAt,
+	}
+}
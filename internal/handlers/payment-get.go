@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+type PaymentGetHandler struct {
+	paymentStore repositories.PaymentStore
+}
+
+func NewPaymentGetHandler(paymentStore repositories.PaymentStore) *PaymentGetHandler {
+	return &PaymentGetHandler{paymentStore}
+}
+
+func (p *PaymentGetHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record, err := p.paymentStore.Get(r.Context(), r.PathValue("correlationId"))
+		if errors.Is(err, repositories.ErrPaymentNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			slog.Error("Error getting payment", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newPaymentRecordOutput(record))
+	})
+}
@@ -1,50 +1,240 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/workers"
+)
+
+const (
+	purgeRequestTimeout = 2000
+
+	purgeTargetDatabase = "database"
+	purgeTargetQueues   = "queues"
+	purgeTargetHealth   = "health"
+
+	purgeStatusOk      = "ok"
+	purgeStatusPurged  = "purged"
+	purgeStatusSkipped = "skipped"
+	purgeStatusFailed  = "failed"
 )
 
 type PaymentPurgeHandler struct {
-	db          *database.Db
-	procService *service.ProcessorService
+	db            *database.Db
+	procService   *service.ProcessorService
+	paymentStore  repositories.PaymentStore
+	healthChecker *workers.HealthChecker
+	dlq           *workers.DLQ
+	adminToken    string
+}
+
+type PurgeScopeOutput struct {
+	Processor string     `json:"processor,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+}
+
+type PurgeTargetOutput struct {
+	Target  string `json:"target"`
+	Status  string `json:"status"`
+	Deleted *int64 `json:"deleted,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type PaymentPurgeOutput struct {
+	Scope   PurgeScopeOutput     `json:"scope"`
+	Targets []*PurgeTargetOutput `json:"targets"`
 }
 
-func NewPaymentsPurgeHandler(db *database.Db, procService *service.ProcessorService) *PaymentPurgeHandler {
-	return &PaymentPurgeHandler{db, procService}
+func NewPaymentsPurgeHandler(db *database.Db, procService *service.ProcessorService, paymentStore repositories.PaymentStore, healthChecker *workers.HealthChecker, dlq *workers.DLQ, adminToken string) *PaymentPurgeHandler {
+	return &PaymentPurgeHandler{db, procService, paymentStore, healthChecker, dlq, adminToken}
 }
 
+// Handle validates every target before purging any, skipping processors when the purge is scoped to a time range
 func (p *PaymentPurgeHandler) Handle() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, resStatus, err := p.procService.MakeRequestDefault(http.MethodPost, "/admin/purge-payments", nil, 0)
-		if err != nil || resStatus > 399 {
-			slog.Error("Error calling default processor payment purge", "err", err, "resStatus", resStatus)
+		token := r.Header.Get("X-Admin-Token")
+		if p.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var filter entities.PaymentFilter
+		var err error
 
-			w.WriteHeader(http.StatusUnprocessableEntity)
+		filter.TimeRange, err = parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		_, resStatus, err = p.procService.MakeRequestFallback(http.MethodPost, "/admin/purge-payments", nil, 0)
-		if err != nil || resStatus > 399 {
-			slog.Error("Error calling default processor payment purge", "err", err, "resStatus", resStatus)
+		filter.Processor = r.URL.Query().Get("processor")
+		if filter.Processor != "" && !slices.Contains(service.Processors, filter.Processor) {
+			http.Error(w, fmt.Sprintf("processor must be one of %s", strings.Join(service.Processors, ", ")), http.StatusBadRequest)
+			return
+		}
+
+		output := PaymentPurgeOutput{Scope: PurgeScopeOutput{filter.Processor, filter.From, filter.To}}
+		processors := service.Processors
+		if filter.Processor != "" {
+			processors = []string{filter.Processor}
+		}
 
-			w.WriteHeader(http.StatusUnprocessableEntity)
+		remote := map[string]*PurgeTargetOutput{}
+		for _, processor := range processors {
+			target := &PurgeTargetOutput{Target: "processor:" + processor}
+			output.Targets = append(output.Targets, target)
+
+			if !filter.IsOpen() {
+				target.Status, target.Reason = purgeStatusSkipped, "processors can only be purged entirely"
+				continue
+			}
+
+			remote[processor] = target
+		}
+
+		dbTarget := &PurgeTargetOutput{Target: purgeTargetDatabase}
+		output.Targets = append(output.Targets, dbTarget)
+
+		valid := p.validate(r.Context(), remote, dbTarget)
+		if !valid {
+			slog.Error("Payment purge aborted, a target failed validation", "scope", output.Scope)
+			writePurgeOutput(w, http.StatusUnprocessableEntity, &output)
 			return
 		}
 
-		_, err = p.db.Conn.Exec(r.Context(), "TRUNCATE TABLE payments;")
+		for processor, target := range remote {
+			_, resStatus, err := p.procService.MakeRequest(processor, http.MethodPost, "/admin/purge-payments", nil, purgeRequestTimeout)
+			if err != nil || resStatus > 399 {
+				slog.Error("Error calling processor payment purge", "processor", processor, "err", err, "resStatus", resStatus)
+				target.Status, target.Reason = purgeStatusFailed, purgeFailureReason(err, resStatus)
+				continue
+			}
+
+			target.Status = purgeStatusPurged
+		}
+
+		deleted, err := p.paymentStore.Purge(r.Context(), filter)
 		if err != nil {
 			slog.Error("Error purging database", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			dbTarget.Status, dbTarget.Reason = purgeStatusFailed, err.Error()
+		} else {
+			dbTarget.Status = purgeStatusPurged
+			if !filter.IsOpen() || filter.Processor != "" {
+				dbTarget.Deleted = &deleted
+			}
 		}
 
-		slog.Info("payments purged")
+		// queued payments were not processed by any processor yet, so a purge scoped to one of them leaves the queues alone
+		if filter.Processor == "" {
+			output.Targets = append(output.Targets, p.purgeQueues(r.Context(), filter.TimeRange))
+		}
+
+		if filter.IsOpen() && filter.Processor == "" {
+			output.Targets = append(output.Targets, p.purgeHealth(r.Context()))
+		}
 
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		status := http.StatusCreated
+		for _, target := range output.Targets {
+			if target.Status == purgeStatusFailed {
+				status = http.StatusBadGateway
+			}
+		}
+
+		slog.Info("payments purged", "scope", output.Scope, "status", status)
+		writePurgeOutput(w, status, &output)
 	})
 }
+
+func (p *PaymentPurgeHandler) validate(ctx context.Context, remote map[string]*PurgeTargetOutput, dbTarget *PurgeTargetOutput) bool {
+	valid := true
+
+	// the admin summary needs the same token as the purge, so a success means the purge will be accepted too
+	for processor, target := range remote {
+		res, err := p.procService.MakeBufferedRequest(processor, http.MethodGet, "/admin/payments-summary", nil, purgeRequestTimeout)
+		if err != nil || res.Status > 399 {
+			status := 0
+			if res != nil {
+				status = res.Status
+			}
+
+			target.Status, target.Reason = purgeStatusFailed, "validation: "+purgeFailureReason(err, status)
+			valid = false
+			continue
+		}
+
+		target.Status = purgeStatusOk
+	}
+
+	err := p.paymentStore.Ping(ctx)
+	if err != nil {
+		dbTarget.Status, dbTarget.Reason = purgeStatusFailed, "validation: "+err.Error()
+		return false
+	}
+
+	dbTarget.Status = purgeStatusOk
+	return valid
+}
+
+// purgeQueues tells every instance, this one included, to drop queued payments in the range
+func (p *PaymentPurgeHandler) purgeQueues(ctx context.Context, tr entities.TimeRange) *PurgeTargetOutput {
+	target := &PurgeTargetOutput{Target: purgeTargetQueues}
+
+	// without a database there are no other instances to tell
+	if p.db == nil {
+		p.dlq.Purge(tr)
+		target.Status = purgeStatusPurged
+		return target
+	}
+
+	payload, _ := json.Marshal(&tr)
+	err := p.db.Notify(ctx, workers.PurgeChannel, string(payload))
+	if err != nil {
+		slog.Error("Error notifying queue purge", "err", err)
+		target.Status, target.Reason = purgeStatusFailed, err.Error()
+		return target
+	}
+
+	target.Status = purgeStatusPurged
+	return target
+}
+
+func (p *PaymentPurgeHandler) purgeHealth(ctx context.Context) *PurgeTargetOutput {
+	target := &PurgeTargetOutput{Target: purgeTargetHealth}
+
+	err := p.healthChecker.ResetStore(ctx)
+	if err != nil {
+		slog.Error("Error resetting processor health", "err", err)
+		target.Status, target.Reason = purgeStatusFailed, err.Error()
+		return target
+	}
+
+	target.Status = purgeStatusPurged
+	return target
+}
+
+func purgeFailureReason(err error, resStatus int) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("processor returned status %d", resStatus)
+}
+
+func writePurgeOutput(w http.ResponseWriter, status int, output *PaymentPurgeOutput) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(output)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+var paymentStatuses = []string{entities.PaymentStatusPending, entities.PaymentStatusProcessed, entities.PaymentStatusParked}
+
+type PaymentSearchHandler struct {
+	paymentStore repositories.PaymentStore
+}
+
+type PaymentSearchOutput struct {
+	Payments   []*PaymentRecordOutput `json:"payments"`
+	NextCursor *string                `json:"nextCursor"`
+}
+
+func NewPaymentSearchHandler(paymentStore repositories.PaymentStore) *PaymentSearchHandler {
+	return &PaymentSearchHandler{paymentStore}
+}
+
+func (p *PaymentSearchHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		filter, err := parsePaymentFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var after *entities.PaymentCursor
+		if qsCursor := q.Get("cursor"); qsCursor != "" {
+			after, err = decodePaymentCursor(qsCursor)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := defaultSearchLimit
+		if qsLimit := q.Get("limit"); qsLimit != "" {
+			limit, err = strconv.Atoi(qsLimit)
+			if err != nil || limit <= 0 || limit > maxSearchLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// one extra row tells whether there is a next page
+		records, err := p.paymentStore.Search(r.Context(), filter, after, limit+1)
+		if err != nil {
+			slog.Error("Error searching payments", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		output := PaymentSearchOutput{Payments: make([]*PaymentRecordOutput, 0, limit)}
+
+		if len(records) > limit {
+			records = records[:limit]
+			last := records[limit-1]
+
+			cursor := encodePaymentCursor(&entities.PaymentCursor{RequestedAt: last.RequestedAt, Id: last.Id})
+			output.NextCursor = &cursor
+		}
+
+		for i := range records {
+			output.Payments = append(output.Payments, newPaymentRecordOutput(&records[i]))
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&output)
+	})
+}
+
+func parsePaymentFilter(q url.Values) (entities.PaymentFilter, error) {
+	var filter entities.PaymentFilter
+	var err error
+
+	filter.TimeRange, err = parseTimeRange(q)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Processor = q.Get("processor")
+	if filter.Processor != "" && !slices.Contains(service.Processors, filter.Processor) {
+		return filter, fmt.Errorf("processor must be one of %s", strings.Join(service.Processors, ", "))
+	}
+
+	filter.Status = q.Get("status")
+	if filter.Status != "" && !slices.Contains(paymentStatuses, filter.Status) {
+		return filter, fmt.Errorf("status must be one of %s", strings.Join(paymentStatuses, ", "))
+	}
+
+	filter.MinAmountCents, err = parseAmountCents(q.Get("minAmount"))
+	if err != nil {
+		return filter, fmt.Errorf("invalid minAmount: %w", err)
+	}
+
+	filter.MaxAmountCents, err = parseAmountCents(q.Get("maxAmount"))
+	if err != nil {
+		return filter, fmt.Errorf("invalid maxAmount: %w", err)
+	}
+
+	if filter.MinAmountCents != nil && filter.MaxAmountCents != nil && *filter.MinAmountCents > *filter.MaxAmountCents {
+		return filter, errors.New("minAmount is greater than maxAmount")
+	}
+
+	return filter, nil
+}
+
+func parseAmountCents(v string) (*int64, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseFloat(v, 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) {
+		return nil, fmt.Errorf("%q is not a positive amount", v)
+	}
+
+	cents := int64(math.Round(amount * 100))
+	return &cents, nil
+}
+
+// cursors are opaque to clients, only this pair needs to round trip
+func encodePaymentCursor(c *entities.PaymentCursor) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%d", c.RequestedAt.UnixMicro(), c.Id))
+}
+
+func decodePaymentCursor(v string) (*entities.PaymentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+
+	requestedAt, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := &entities.PaymentCursor{RequestedAt: time.UnixMicro(requestedAt).UTC()}
+
+	cursor.Id, err = strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return cursor, nil
+}
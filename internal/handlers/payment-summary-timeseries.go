@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const maxTimeseriesBuckets = 10000
+
+var timeseriesIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+type PaymentSummaryTimeseriesHandler struct {
+	paymentStore repositories.PaymentStore
+}
+
+type SummaryBucket struct {
+	Start      time.Time                   `json:"start"`
+	Processors map[string]ProcessorSummary `json:"processors"`
+}
+
+type PaymentSummaryTimeseriesOutput struct {
+	Interval string          `json:"interval"`
+	Buckets  []SummaryBucket `json:"buckets"`
+}
+
+func NewPaymentSummaryTimeseriesHandler(paymentStore repositories.PaymentStore) *PaymentSummaryTimeseriesHandler {
+	return &PaymentSummaryTimeseriesHandler{paymentStore}
+}
+
+func (p *PaymentSummaryTimeseriesHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qsInterval := r.URL.Query().Get("interval")
+		interval, ok := timeseriesIntervals[qsInterval]
+		if !ok {
+			http.Error(w, "interval must be one of 1m, 1h or 1d", http.StatusBadRequest)
+			return
+		}
+
+		tr, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// both bounds are needed to know which empty buckets to fill in
+		if tr.From == nil || tr.To == nil {
+			http.Error(w, "from and to are required", http.StatusBadRequest)
+			return
+		}
+
+		first, last := tr.From.Truncate(interval), tr.To.Truncate(interval)
+		if int(last.Sub(first)/interval)+1 > maxTimeseriesBuckets {
+			http.Error(w, fmt.Sprintf("range spans more than %d buckets", maxTimeseriesBuckets), http.StatusBadRequest)
+			return
+		}
+
+		buckets, err := p.paymentStore.SummaryBuckets(r.Context(), tr, interval)
+		if errors.Is(err, repositories.ErrRangeArchived) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err != nil {
+			slog.Error("Error reading summary timeseries from database:", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&PaymentSummaryTimeseriesOutput{
+			Interval: qsInterval,
+			Buckets:  fillSummaryBuckets(buckets, first, last, interval),
+		})
+	})
+}
+
+// fillSummaryBuckets returns one bucket per interval between first and last, every one of them listing all known processors
+func fillSummaryBuckets(buckets []entities.PaymentBucket, first, last time.Time, interval time.Duration) []SummaryBucket {
+	processors := append([]string{}, service.Processors...)
+	known := make(map[string]bool, len(processors))
+	for _, processor := range processors {
+		known[processor] = true
+	}
+
+	byStart := make(map[int64]entities.PaymentBucket, len(buckets))
+	for _, bucket := range buckets {
+		byStart[bucket.Start.UnixMilli()] = bucket
+
+		for processor := range bucket.Processors {
+			if !known[processor] {
+				known[processor] = true
+				processors = append(processors, processor)
+			}
+		}
+	}
+
+	filled := make([]SummaryBucket, 0, int(last.Sub(first)/interval)+1)
+	for start := first; !start.After(last); start = start.Add(interval) {
+		bucket := SummaryBucket{Start: start, Processors: make(map[string]ProcessorSummary, len(processors))}
+
+		totals := byStart[start.UnixMilli()].Processors
+		for _, processor := range processors {
+			bucket.Processors[processor] = newProcessorSummary(totals[processor])
+		}
+
+		filled = append(filled, bucket)
+	}
+
+	return filled
+}
@@ -1,96 +1,147 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
-	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
 )
 
+const (
+	summaryConsistencySettled = "settled"
+	settlePollInterval        = 50 * time.Millisecond
+
+	// time for a payment still being forwarded by any instance to end up either processed or recorded as pending
+	settleInsertMargin = 500 * time.Millisecond
+)
+
 type PaymentGetSummaryHandler struct {
-	db *database.Db
+	paymentStore  repositories.PaymentStore
+	procService   *service.ProcessorService
+	settleTimeout time.Duration
 }
 
 type ProcessorSummary struct {
-	TotalRequests int     `json:"totalRequests"`
+	TotalRequests int64   `json:"totalRequests"`
 	TotalAmount   float64 `json:"totalAmount"`
 }
 
+type SettlementOutput struct {
+	Settled bool  `json:"settled"`
+	Pending int64 `json:"pending"`
+}
+
 type PaymentSummaryOutput struct {
-	Default  ProcessorSummary `json:"default"`
-	Fallback ProcessorSummary `json:"fallback"`
+	Default    ProcessorSummary  `json:"default"`
+	Fallback   ProcessorSummary  `json:"fallback"`
+	Settlement *SettlementOutput `json:"settlement,omitempty"`
 }
 
 func (p PaymentSummaryOutput) String() string {
 	return fmt.Sprintf("Default.TotalRequests: %v | Default.TotalAmount: %v | Fallback.TotalRequests: %v | Fallback.TotalAmount: %v", p.Default.TotalRequests, p.Default.TotalAmount, p.Fallback.TotalRequests, p.Fallback.TotalAmount)
 }
 
-func NewPaymentGetSummaryHandler(db *database.Db) *PaymentGetSummaryHandler {
-	return &PaymentGetSummaryHandler{db}
+func NewPaymentGetSummaryHandler(paymentStore repositories.PaymentStore, procService *service.ProcessorService, settleTimeout time.Duration) *PaymentGetSummaryHandler {
+	return &PaymentGetSummaryHandler{paymentStore, procService, settleTimeout}
 }
 
 func (p *PaymentGetSummaryHandler) Handle() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var sqlQuery string
-		var sqlQueryParams []any
-
-		qsFrom := r.URL.Query().Get("from")
-		qsTo := r.URL.Query().Get("to")
-
-		if qsFrom != "" || qsTo != "" {
-			sqlQueryParams = []any{qsFrom, qsTo}
-			sqlQuery = `
-				SELECT
-				p.processor_used AS processor,
-				COUNT(*) AS total_requests,
-				SUM(p.amount)/100 AS total_amount
-				FROM payments p
-				WHERE p.processor_used IS NOT NULL AND p.requested_at BETWEEN $1 AND $2
-				GROUP BY p.processor_used;
-			`
-		} else {
-			sqlQuery = `
-				SELECT
-				p.processor_used AS processor,
-				COUNT(*) AS total_requests,
-				SUM(p.amount)/100 AS total_amount
-				FROM payments p
-				WHERE p.processor_used IS NOT NULL
-				GROUP BY p.processor_used;
-			`
+		tr, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		res, err := p.db.Conn.Query(r.Context(), sqlQuery, sqlQueryParams...)
-		if err != nil {
-			slog.Error("Error reading summary rom database:", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		consistency := r.URL.Query().Get("consistency")
+		if consistency != "" && consistency != summaryConsistencySettled {
+			http.Error(w, "consistency must be settled when given", http.StatusBadRequest)
+			return
 		}
 
-		var summary PaymentSummaryOutput
-		var processor string
-		var totalRequests int
-		var totalAmount float64
+		if consistency == summaryConsistencySettled && p.settleTimeout <= 0 {
+			http.Error(w, "settled summaries are disabled", http.StatusBadRequest)
+			return
+		}
+
+		var settlement *SettlementOutput
+		ctx := r.Context()
+		if consistency == summaryConsistencySettled {
+			// a replica may not have the payments that just settled yet
+			ctx = repositories.WithPrimaryReads(ctx)
 
-		for res.Next() {
-			err = res.Scan(&processor, &totalRequests, &totalAmount)
+			to := time.Now().UTC()
+			if tr.To != nil {
+				to = *tr.To
+			}
+
+			settlement, err = p.waitSettled(ctx, to)
 			if err != nil {
-				slog.Error(fmt.Sprintf("Error scanning columns from database: %v", err))
+				slog.Error("Error waiting for payments to settle:", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+		}
 
-			if processor == service.ProcessorDefault {
-				summary.Default = ProcessorSummary{totalRequests, totalAmount}
-			} else {
-				summary.Fallback = ProcessorSummary{totalRequests, totalAmount}
-			}
+		totals, err := p.paymentStore.Summary(ctx, tr)
+		if errors.Is(err, repositories.ErrRangeArchived) || errors.Is(err, repositories.ErrAggregatesPruned) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
 		}
 
-		slog.Info("Summary read", "summary", summary.String(), "qsFrom", qsFrom, "qsTo", qsTo)
+		if err != nil {
+			slog.Error("Error reading summary rom database:", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		summary := PaymentSummaryOutput{
+			Default:    newProcessorSummary(totals[service.ProcessorDefault]),
+			Fallback:   newProcessorSummary(totals[service.ProcessorFallback]),
+			Settlement: settlement,
+		}
+
+		slog.Info("Summary read", "summary", summary.String(), "from", tr.From, "to", tr.To)
 
 		w.Header().Add("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(&summary)
 	})
 }
+
+func newProcessorSummary(totals entities.PaymentTotals) ProcessorSummary {
+	return ProcessorSummary{totals.TotalRequests, totals.TotalAmount()}
+}
+
+// waitSettled blocks until every payment requested up to the given time is processed or parked, or the settle timeout is hit
+func (p *PaymentGetSummaryHandler) waitSettled(ctx context.Context, to time.Time) (*SettlementOutput, error) {
+	deadline := time.Now().Add(p.settleTimeout)
+	inFlightUntil := to.Add(p.procService.DefaultTimeout() + settleInsertMargin)
+
+	for {
+		pending, err := p.paymentStore.PendingCount(ctx, to)
+		if err != nil {
+			return nil, err
+		}
+
+		if pending == 0 && !time.Now().Before(inFlightUntil) {
+			return &SettlementOutput{Settled: true}, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return &SettlementOutput{Settled: false, Pending: pending}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(settlePollInterval):
+		}
+	}
+}
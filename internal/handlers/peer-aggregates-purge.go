@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+// PeerAggregatesPurgeHandler drops the aggregates of this instance alone, on behalf of the peer that took the purge request
+type PeerAggregatesPurgeHandler struct {
+	aggregates *repositories.PaymentAggregates
+	peerToken  string
+}
+
+func NewPeerAggregatesPurgeHandler(aggregates *repositories.PaymentAggregates, peerToken string) *PeerAggregatesPurgeHandler {
+	return &PeerAggregatesPurgeHandler{aggregates, peerToken}
+}
+
+func (p *PeerAggregatesPurgeHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Peer-Token")
+		if p.peerToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.peerToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var filter entities.PaymentFilter
+		var err error
+
+		filter.TimeRange, err = parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Processor = r.URL.Query().Get("processor")
+
+		p.aggregates.Purge(filter)
+		slog.Info("payment aggregates purged by a peer", "from", filter.From, "to", filter.To, "processor", filter.Processor)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
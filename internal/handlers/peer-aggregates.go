@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+// PeerAggregatesHandler serves the payment aggregates of this instance alone, for its peers to sum into their summaries
+type PeerAggregatesHandler struct {
+	aggregates *repositories.PaymentAggregates
+	peerToken  string
+}
+
+type PeerTotalsOutput struct {
+	TotalRequests int64 `json:"totalRequests"`
+	AmountCents   int64 `json:"amountCents"`
+}
+
+func NewPeerAggregatesHandler(aggregates *repositories.PaymentAggregates, peerToken string) *PeerAggregatesHandler {
+	return &PeerAggregatesHandler{aggregates, peerToken}
+}
+
+func (p *PeerAggregatesHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Peer-Token")
+		if p.peerToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.peerToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		tr, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		summary, err := p.aggregates.Summary(tr)
+		if errors.Is(err, repositories.ErrAggregatesPruned) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		output := make(map[string]PeerTotalsOutput)
+		for processor, totals := range summary {
+			output[processor] = PeerTotalsOutput{totals.TotalRequests, totals.AmountCents}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(output)
+	})
+}
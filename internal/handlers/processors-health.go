@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/workers"
+)
+
+const defaultHealthHistoryWindow = 15 * time.Minute
+
+type ProcessorsHealthHandler struct {
+	healthChecker *workers.HealthChecker
+}
+
+type ProcessorHealthCurrent struct {
+	Failing         bool       `json:"failing"`
+	Unknown         bool       `json:"unknown"`
+	Stale           bool       `json:"stale"`
+	MinResponseTime int64      `json:"minResponseTime"`
+	FallingCycles   int64      `json:"fallingCycles"`
+	LastSeenAt      *time.Time `json:"lastSeenAt"`
+	UpdatedAt       *time.Time `json:"updatedAt"`
+}
+
+type FailingPeriod struct {
+	From time.Time  `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+type ResponseTimePoint struct {
+	At              time.Time `json:"at"`
+	MinResponseTime int64     `json:"minResponseTime"`
+}
+
+type ProbeError struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error"`
+}
+
+type ProcessorHealthReport struct {
+	Current          ProcessorHealthCurrent `json:"current"`
+	FailingPeriods   []FailingPeriod        `json:"failingPeriods"`
+	MinResponseTimes []ResponseTimePoint    `json:"minResponseTimes"`
+	ProbeErrors      []ProbeError           `json:"probeErrors"`
+}
+
+func NewProcessorsHealthHandler(healthChecker *workers.HealthChecker) *ProcessorsHealthHandler {
+	return &ProcessorsHealthHandler{healthChecker}
+}
+
+func (p *ProcessorsHealthHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		window := defaultHealthHistoryWindow
+
+		if qsWindow := r.URL.Query().Get("window"); qsWindow != "" {
+			var err error
+
+			window, err = time.ParseDuration(qsWindow)
+			if err != nil || window <= 0 || window > workers.HealthHistoryRetention {
+				http.Error(w, fmt.Sprintf("window must be a duration up to %v", workers.HealthHistoryRetention), http.StatusBadRequest)
+				return
+			}
+		}
+
+		since := time.Now().Add(-window)
+		reports := make(map[string]*ProcessorHealthReport, len(service.Processors))
+
+		for _, processor := range service.Processors {
+			probes, err := p.healthChecker.GetHealthHistory(r.Context(), processor, since)
+			if err != nil {
+				slog.Error("Error reading health history", "processor", processor, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			health, _ := p.healthChecker.CurrentHealth(processor)
+			reports[processor] = buildProcessorHealthReport(&health, probes)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	})
+}
+
+func buildProcessorHealthReport(health *workers.Health, probes []workers.HealthProbe) *ProcessorHealthReport {
+	report := &ProcessorHealthReport{
+		Current: ProcessorHealthCurrent{
+			Failing:         health.Failing,
+			Unknown:         health.Unknown,
+			Stale:           health.IsStale(),
+			MinResponseTime: health.MinResponseTime,
+			FallingCycles:   health.FallingCycles,
+			LastSeenAt:      optionalTime(health.LastSeenAt),
+			UpdatedAt:       optionalTime(health.UpdatedAt),
+		},
+		FailingPeriods:   []FailingPeriod{},
+		MinResponseTimes: []ResponseTimePoint{},
+		ProbeErrors:      []ProbeError{},
+	}
+
+	var open *FailingPeriod
+
+	for _, probe := range probes {
+		if probe.Error != nil {
+			report.ProbeErrors = append(report.ProbeErrors, ProbeError{probe.ProbedAt, *probe.Error})
+			continue
+		}
+
+		report.MinResponseTimes = append(report.MinResponseTimes, ResponseTimePoint{probe.ProbedAt, *probe.MinResponseTime})
+
+		if *probe.Failing && open == nil {
+			open = &FailingPeriod{From: probe.ProbedAt}
+		} else if !*probe.Failing && open != nil {
+			to := probe.ProbedAt
+			open.To = &to
+			report.FailingPeriods = append(report.FailingPeriods, *open)
+			open = nil
+		}
+	}
+
+	// still failing at the last probe
+	if open != nil {
+		report.FailingPeriods = append(report.FailingPeriods, *open)
+	}
+
+	return report
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+const (
+	readinessOk       = "ok"
+	readinessDegraded = "degraded"
+)
+
+type ReadinessHandler struct {
+	// nil when payments are kept in memory, there is no database to be down then
+	degraded *repositories.DegradedPaymentStore
+}
+
+type ReadinessOutput struct {
+	Status         string     `json:"status"`
+	DegradedSince  *time.Time `json:"degradedSince,omitempty"`
+	BufferedWrites int        `json:"bufferedWrites"`
+}
+
+func NewReadinessHandler(degraded *repositories.DegradedPaymentStore) *ReadinessHandler {
+	return &ReadinessHandler{degraded}
+}
+
+// Handle reports degraded while the database is down, and only fails once the local write log is full
+func (h *ReadinessHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		output := ReadinessOutput{Status: readinessOk}
+		status := http.StatusOK
+
+		if h.degraded != nil {
+			ds := h.degraded.Status()
+			output.BufferedWrites = ds.BufferedWrites
+
+			if !ds.Available || ds.BufferedWrites > 0 {
+				output.Status = readinessDegraded
+				output.DegradedSince = ds.DegradedSince
+			}
+
+			if ds.LogFull {
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(&output)
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+type ReconciliationHandler struct {
+	reconService *service.ReconciliationService
+}
+
+type ProcessorReconciliationOutput struct {
+	Local            ProcessorSummary  `json:"local"`
+	Remote           *ProcessorSummary `json:"remote"`
+	RequestsDelta    int64             `json:"requestsDelta"`
+	AmountDelta      float64           `json:"amountDelta"`
+	SuspectedMissing []string          `json:"suspectedMissing,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+type ReconciliationOutput struct {
+	From       *time.Time                               `json:"from"`
+	To         *time.Time                               `json:"to"`
+	At         time.Time                                `json:"at"`
+	Processors map[string]ProcessorReconciliationOutput `json:"processors"`
+}
+
+func NewReconciliationHandler(reconService *service.ReconciliationService) *ReconciliationHandler {
+	return &ReconciliationHandler{reconService}
+}
+
+func (h *ReconciliationHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr, err := parseTimeRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := h.reconService.Reconcile(r.Context(), tr)
+		if err != nil {
+			slog.Error("Error reconciling payments", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		output := ReconciliationOutput{
+			From:       report.Range.From,
+			To:         report.Range.To,
+			At:         report.At,
+			Processors: make(map[string]ProcessorReconciliationOutput, len(report.Processors)),
+		}
+
+		for _, rec := range report.Processors {
+			recOutput := ProcessorReconciliationOutput{
+				Local:            newProcessorSummary(rec.Local),
+				RequestsDelta:    rec.RequestsDelta(),
+				AmountDelta:      float64(rec.AmountDeltaCents()) / 100,
+				SuspectedMissing: rec.SuspectedMissing,
+			}
+
+			if rec.Remote != nil {
+				remote := newProcessorSummary(*rec.Remote)
+				recOutput.Remote = &remote
+			}
+
+			if rec.Error != nil {
+				recOutput.Error = rec.Error.Error()
+			}
+
+			output.Processors[rec.Processor] = recOutput
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&output)
+	})
+}
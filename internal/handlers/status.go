@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/workers"
+)
+
+type StatusHandler struct {
+	elector *workers.LeaderElector
+}
+
+type StatusOutput struct {
+	Node     string `json:"node"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+func NewStatusHandler(elector *workers.LeaderElector) *StatusHandler {
+	return &StatusHandler{elector}
+}
+
+func (s *StatusHandler) Handle() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leader, err := s.elector.Leader(r.Context())
+		if err != nil {
+			slog.Error("Error reading current leader", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&StatusOutput{
+			Node:     s.elector.Node(),
+			Leader:   leader,
+			IsLeader: s.elector.IsLeader(),
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+// timestamps without an offset are taken as UTC
+var timeRangeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTimeRange reads the inclusive from and to query params, toExclusive=true leaves to out of the range
+func parseTimeRange(q url.Values) (entities.TimeRange, error) {
+	var tr entities.TimeRange
+	var err error
+
+	toExclusive := false
+	if v := q.Get("toExclusive"); v != "" {
+		toExclusive, err = strconv.ParseBool(v)
+		if err != nil {
+			return tr, fmt.Errorf("invalid toExclusive: %q is not a boolean", v)
+		}
+	}
+
+	tr.From, err = parseTimeRangeBound(q.Get("from"))
+	if err != nil {
+		return tr, fmt.Errorf("invalid from: %w", err)
+	}
+
+	tr.To, err = parseTimeRangeBound(q.Get("to"))
+	if err != nil {
+		return tr, fmt.Errorf("invalid to: %w", err)
+	}
+
+	if tr.From != nil && tr.To != nil && tr.From.After(*tr.To) {
+		return tr, errors.New("from is after to")
+	}
+
+	// bounds have millisecond precision, so an exclusive to is the inclusive one a millisecond before
+	if toExclusive && tr.To != nil {
+		if tr.From != nil && tr.From.Equal(*tr.To) {
+			return tr, errors.New("from must be before an exclusive to")
+		}
+
+		to := tr.To.Add(-time.Millisecond)
+		tr.To = &to
+	}
+
+	return tr, nil
+}
+
+func parseTimeRangeBound(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	for _, layout := range timeRangeLayouts {
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			continue
+		}
+
+		// requested_at is stored truncated to milliseconds, so bounds must be too
+		t = t.UTC().Truncate(time.Millisecond)
+		return &t, nil
+	}
+
+	return nil, fmt.Errorf("%q is not an ISO 8601 timestamp", v)
+}
@@ -3,17 +3,49 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+
+	// how long the DLQ backs off between retries, a health check interval
+	dlqRetryIntervalMs = 5500
+)
+
 type Config struct {
-	Port                 int
-	LogLevel             int
-	DefaultProcessorURL  string
-	FallBackProcessorURL string
-	ProcessorAPIToken    string
-	StartHealthChecker   bool
-	DbConnCfg            *DbConnCfg
+	Port                    int
+	LogLevel                int
+	DefaultProcessorURL     string
+	FallBackProcessorURL    string
+	ProcessorAPIToken       string
+	AdminToken              string
+	Hostname                string
+	Store                   string
+	WriteBehindSize         int
+	WriteBehindInterval     time.Duration
+	PaymentsRetention       time.Duration
+	PaymentsArchiveDir      string
+	OutboxEnabled           bool
+	DegradedLogMaxEntries   int
+	DegradedLogDir          string
+	WalDir                  string
+	WalSegmentMaxBytes      int64
+	WalSyncInterval         time.Duration
+	AggregationEnabled      bool
+	AggregationDir          string
+	AggregationPeers        []string
+	AggregationSocket       string
+	AggregationPeerTimeout  time.Duration
+	AggregationPeerToken    string
+	SummarySettleTimeout    time.Duration
+	ReconcileInterval       time.Duration
+	ReconcileWindow         time.Duration
+	ReconcileDriftThreshold int64
+	ReconcileAutoRepair     bool
+	DbConnCfg               *DbConnCfg
 }
 
 type DbConnCfg struct {
@@ -22,15 +54,63 @@ type DbConnCfg struct {
 	Port             int
 	Username         string
 	Password         string
+	ApplicationName  string
 	PoolMaxLifetime  time.Duration
 	PoolMinIdleConns int
 	PoolMaxOpenConns int
 	PoolMinOpenConns int
+	// read-only endpoints read from these when they lag behind the primary by at most ReplicaMaxStaleness
+	ReplicaDSNs         []string
+	ReplicaMaxStaleness time.Duration
 }
 
 func LoadConfig() *Config {
 	appPort, _ := strconv.Atoi(os.Getenv("APP_PORT"))
 	logLevel, _ := strconv.Atoi(os.Getenv("LOG_LEVEL"))
+	reconcileInterval, _ := strconv.Atoi(os.Getenv("RECONCILE_INTERVAL_S"))
+	reconcileWindow, _ := strconv.Atoi(os.Getenv("RECONCILE_WINDOW_MIN"))
+	reconcileDriftThreshold, _ := strconv.ParseInt(os.Getenv("RECONCILE_DRIFT_THRESHOLD"), 10, 64)
+	writeBehindSize, _ := strconv.Atoi(os.Getenv("WRITE_BEHIND_SIZE"))
+	writeBehindInterval, _ := strconv.Atoi(os.Getenv("WRITE_BEHIND_INTERVAL_MS"))
+	paymentsRetention, _ := strconv.Atoi(os.Getenv("PAYMENTS_RETENTION_DAYS"))
+
+	// long enough for a buffered write to be flushed and a deferred payment to be retried once. 0 disables settled summaries
+	summarySettleTimeout, err := strconv.Atoi(os.Getenv("SUMMARY_SETTLE_TIMEOUT_MS"))
+	if err != nil {
+		summarySettleTimeout = writeBehindInterval + dlqRetryIntervalMs
+	}
+
+	degradedLogMaxEntries, err := strconv.Atoi(os.Getenv("DEGRADED_LOG_MAX_ENTRIES"))
+	if err != nil {
+		degradedLogMaxEntries = 100000
+	}
+
+	walSegmentMaxBytes, _ := strconv.ParseInt(os.Getenv("WAL_SEGMENT_MAX_BYTES"), 10, 64)
+	if walSegmentMaxBytes <= 0 {
+		walSegmentMaxBytes = 64 << 20
+	}
+
+	walSyncInterval, _ := strconv.Atoi(os.Getenv("WAL_SYNC_INTERVAL_MS"))
+	if walSyncInterval <= 0 {
+		walSyncInterval = 2
+	}
+
+	aggregationPeerTimeout, _ := strconv.Atoi(os.Getenv("AGGREGATION_PEER_TIMEOUT_MS"))
+	if aggregationPeerTimeout <= 0 {
+		aggregationPeerTimeout = 500
+	}
+
+	aggregationDir := os.Getenv("AGGREGATION_DIR")
+	if aggregationDir == "" {
+		aggregationDir = "aggregates"
+	}
+
+	var aggregationPeers []string
+	for _, peer := range strings.Split(os.Getenv("AGGREGATION_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			aggregationPeers = append(aggregationPeers, peer)
+		}
+	}
 
 	dbPort, _ := strconv.Atoi(os.Getenv("DB_PORT"))
 	poolMaxLifetime, _ := strconv.Atoi(os.Getenv("DB_POOL_MAX_LIFETIME"))
@@ -38,25 +118,75 @@ func LoadConfig() *Config {
 	poolMaxOpenConns, _ := strconv.Atoi(os.Getenv("DB_POOL_MAX_OPEN_CONNS"))
 	poolMinOpenConns, _ := strconv.Atoi(os.Getenv("DB_POOL_MIN_OPEN_CONNS"))
 
+	replicaMaxStaleness, err := strconv.Atoi(os.Getenv("DB_REPLICA_MAX_STALENESS_MS"))
+	if err != nil {
+		replicaMaxStaleness = 1000
+	}
+
+	var replicaDSNs []string
+	for _, dsn := range strings.Split(os.Getenv("DB_REPLICA_DSNS"), ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			replicaDSNs = append(replicaDSNs, dsn)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	archiveDir := os.Getenv("PAYMENTS_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "archive"
+	}
+
+	store := os.Getenv("STORE")
+	if store == "" {
+		store = StorePostgres
+	}
+
 	dcCfg := &DbConnCfg{
-		Database:         os.Getenv("APP_DATABASE"),
-		Host:             os.Getenv("DB_HOST"),
-		Username:         os.Getenv("DB_USERNAME"),
-		Password:         os.Getenv("DB_PASSWORD"),
-		Port:             dbPort,
-		PoolMaxLifetime:  time.Duration(poolMaxLifetime) * time.Second,
-		PoolMinIdleConns: poolMaxIdleConns,
-		PoolMaxOpenConns: poolMaxOpenConns,
-		PoolMinOpenConns: poolMinOpenConns,
+		Database:            os.Getenv("APP_DATABASE"),
+		Host:                os.Getenv("DB_HOST"),
+		Username:            os.Getenv("DB_USERNAME"),
+		Password:            os.Getenv("DB_PASSWORD"),
+		ApplicationName:     hostname,
+		Port:                dbPort,
+		PoolMaxLifetime:     time.Duration(poolMaxLifetime) * time.Second,
+		PoolMinIdleConns:    poolMaxIdleConns,
+		PoolMaxOpenConns:    poolMaxOpenConns,
+		PoolMinOpenConns:    poolMinOpenConns,
+		ReplicaDSNs:         replicaDSNs,
+		ReplicaMaxStaleness: time.Duration(replicaMaxStaleness) * time.Millisecond,
 	}
 
 	return &Config{
-		Port:                 appPort,
-		LogLevel:             logLevel,
-		DefaultProcessorURL:  os.Getenv("DEFAULT_PROCESSOR_URL"),
-		FallBackProcessorURL: os.Getenv("FALLBACK_PROCESSOR_URL"),
-		ProcessorAPIToken:    os.Getenv("PROCESSOR_API_TOKEN"),
-		StartHealthChecker:   os.Getenv("START_HEALTH_CHECKER") == "1",
-		DbConnCfg:            dcCfg,
+		Port:                    appPort,
+		LogLevel:                logLevel,
+		DefaultProcessorURL:     os.Getenv("DEFAULT_PROCESSOR_URL"),
+		FallBackProcessorURL:    os.Getenv("FALLBACK_PROCESSOR_URL"),
+		ProcessorAPIToken:       os.Getenv("PROCESSOR_API_TOKEN"),
+		AdminToken:              os.Getenv("ADMIN_TOKEN"),
+		Hostname:                hostname,
+		Store:                   store,
+		WriteBehindSize:         writeBehindSize,
+		WriteBehindInterval:     time.Duration(writeBehindInterval) * time.Millisecond,
+		PaymentsRetention:       time.Duration(paymentsRetention) * 24 * time.Hour,
+		PaymentsArchiveDir:      archiveDir,
+		OutboxEnabled:           os.Getenv("OUTBOX_ENABLED") == "1",
+		DegradedLogMaxEntries:   degradedLogMaxEntries,
+		DegradedLogDir:          os.Getenv("DEGRADED_LOG_DIR"),
+		WalDir:                  os.Getenv("WAL_DIR"),
+		WalSegmentMaxBytes:      walSegmentMaxBytes,
+		WalSyncInterval:         time.Duration(walSyncInterval) * time.Millisecond,
+		AggregationEnabled:      os.Getenv("AGGREGATION_ENABLED") == "1",
+		AggregationDir:          aggregationDir,
+		AggregationPeers:        aggregationPeers,
+		AggregationSocket:       os.Getenv("AGGREGATION_SOCKET"),
+		AggregationPeerTimeout:  time.Duration(aggregationPeerTimeout) * time.Millisecond,
+		AggregationPeerToken:    os.Getenv("AGGREGATION_PEER_TOKEN"),
+		SummarySettleTimeout:    time.Duration(summarySettleTimeout) * time.Millisecond,
+		ReconcileInterval:       time.Duration(reconcileInterval) * time.Second,
+		ReconcileWindow:         time.Duration(reconcileWindow) * time.Minute,
+		ReconcileDriftThreshold: reconcileDriftThreshold,
+		ReconcileAutoRepair:     os.Getenv("RECONCILE_AUTO_REPAIR") == "1",
+		DbConnCfg:               dcCfg,
 	}
 }
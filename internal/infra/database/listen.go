@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const listenRetryInterval = 1 * time.Second
+
+// Listen calls fn with the payload of every notification sent to channel, on a connection of its own that is re-established if lost
+func (d *Db) Listen(channel string, fn func(payload string)) {
+	go func() {
+		for {
+			err := d.listen(channel, fn)
+			slog.Error("Stopped listening for notifications, retrying", "channel", channel, "err", err)
+			time.Sleep(listenRetryInterval)
+		}
+	}()
+}
+
+func (d *Db) Notify(ctx context.Context, channel, payload string) error {
+	_, err := d.Conn.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+func (d *Db) listen(channel string, fn func(payload string)) error {
+	ctx := context.Background()
+
+	poolConn, err := d.Conn.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a listening session must not go back to the pool
+	conn := poolConn.Hijack()
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		fn(notification.Payload)
+	}
+}
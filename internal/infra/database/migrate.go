@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// every instance migrates on startup, the lock makes the others wait for the first one instead of racing it
+const migrationsLockID = 2026
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+// MigrateUp applies every pending migration in order and returns how many were applied
+func (d *Db) MigrateUp(ctx context.Context) (int, error) {
+	var count int
+
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn, migrations []migration, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+
+			err := runMigration(ctx, conn, m.up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+			}
+
+			slog.Info("applied migration", "version", m.version, "name", m.name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and returns how many were reverted
+func (d *Db) MigrateDown(ctx context.Context, steps int) (int, error) {
+	var count int
+
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn, migrations []migration, applied map[int]time.Time) error {
+		for _, m := range slices.Backward(migrations) {
+			if count == steps {
+				break
+			}
+
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+
+			err := runMigration(ctx, conn, m.down, "DELETE FROM schema_migrations WHERE version = $1", m.version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s: %w", m.version, m.name, err)
+			}
+
+			slog.Info("reverted migration", "version", m.version, "name", m.name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (d *Db) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn, migrations []migration, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			status := MigrationStatus{Version: m.version, Name: m.name}
+			if appliedAt, ok := applied[m.version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withMigrationLock holds the migrations advisory lock on a single connection, since session locks belong to it, while fn runs
+func (d *Db) withMigrationLock(ctx context.Context, fn func(*pgxpool.Conn, []migration, map[int]time.Time) error) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.Conn.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (version)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, migrations, applied)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	res, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	applied := make(map[int]time.Time)
+
+	var version int
+	var appliedAt time.Time
+
+	for res.Next() {
+		err = res.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, res.Err()
+}
+
+// runMigration runs the migration script and records it in schema_migrations in the same transaction
+func runMigration(ctx context.Context, conn *pgxpool.Conn, script string, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// no arguments means the simple protocol, which accepts several statements at once
+	_, err = tx.Exec(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// loadMigrations reads the embedded NNNN_name.up.sql and NNNN_name.down.sql pairs, ordered by version
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		rawVersion, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		script, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		} else if m.name != name {
+			return nil, fmt.Errorf("migration %04d has two names, %q and %q", version, m.name, name)
+		}
+
+		if direction == "up" {
+			m.up = string(script)
+		} else {
+			m.down = string(script)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both an up and a down script", m.version, m.name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return a.version - b.version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,13 @@
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS payments (
+    id SERIAL NOT NULL,
+    correlation_id UUID NULL DEFAULT NULL,
+    amount BIGINT NULL DEFAULT NULL,
+    processor_used VARCHAR(8) NULL DEFAULT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id),
+    CONSTRAINT payments_correlation_id_key UNIQUE (correlation_id)
+);
+
+CREATE INDEX IF NOT EXISTS requested_at_idx ON payments (requested_at);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS processor_health (
+    processor VARCHAR(8) NOT NULL,
+    is_falling BOOLEAN NULL DEFAULT NULL,
+    min_response_time BIGINT NULL DEFAULT NULL,
+    falling_cycles BIGINT NULL DEFAULT NULL,
+    PRIMARY KEY (processor)
+);
+
+-- the table may predate these columns
+ALTER TABLE processor_health ADD COLUMN IF NOT EXISTS is_unknown BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE processor_health ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NULL DEFAULT NULL;
+ALTER TABLE processor_health ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NULL DEFAULT NULL;
+
+INSERT INTO processor_health
+(processor, is_falling, min_response_time, falling_cycles)
+VALUES ('default', 'false', 0, 0), ('fallback', 'false', 0, 0)
+ON CONFLICT (processor) DO NOTHING;
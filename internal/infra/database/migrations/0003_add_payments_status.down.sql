@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS pending_requested_at_idx;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS status;
@@ -0,0 +1,3 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS status VARCHAR(9) NOT NULL DEFAULT 'processed';
+
+CREATE INDEX IF NOT EXISTS pending_requested_at_idx ON payments (requested_at) WHERE status = 'pending';
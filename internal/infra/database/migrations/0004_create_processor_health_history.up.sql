@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS processor_health_history (
+    id BIGSERIAL NOT NULL,
+    processor VARCHAR(8) NOT NULL,
+    is_falling BOOLEAN NULL DEFAULT NULL,
+    min_response_time BIGINT NULL DEFAULT NULL,
+    probe_error TEXT NULL DEFAULT NULL,
+    probed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS processor_health_history_probed_at_idx ON processor_health_history (processor, probed_at);
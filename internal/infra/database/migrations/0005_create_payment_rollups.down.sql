@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS payments_rollup_trigger ON payments;
+
+DROP FUNCTION IF EXISTS payments_rollup();
+
+DROP TABLE IF EXISTS payment_rollups;
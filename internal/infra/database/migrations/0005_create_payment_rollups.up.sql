@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS payment_rollups (
+    processor VARCHAR(8) NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    total_requests BIGINT NOT NULL DEFAULT 0,
+    total_amount BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (processor, bucket)
+);
+
+-- payments recorded before the trigger existed
+INSERT INTO payment_rollups (processor, bucket, total_requests, total_amount)
+SELECT processor_used, date_trunc('minute', requested_at), COUNT(*), COALESCE(SUM(amount), 0)
+FROM payments
+WHERE status = 'processed' AND processor_used IS NOT NULL
+GROUP BY 1, 2
+ON CONFLICT DO NOTHING;
+
+-- keeps per-minute totals of processed payments in step with the payments table, in the same transaction
+CREATE OR REPLACE FUNCTION payments_rollup() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.processor_used IS NOT NULL THEN
+        UPDATE payment_rollups
+        SET total_requests = total_requests - 1, total_amount = total_amount - COALESCE(OLD.amount, 0)
+        WHERE processor = OLD.processor_used AND bucket = date_trunc('minute', OLD.requested_at);
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.processor_used IS NOT NULL THEN
+        INSERT INTO payment_rollups (processor, bucket, total_requests, total_amount)
+        VALUES (NEW.processor_used, date_trunc('minute', NEW.requested_at), 1, COALESCE(NEW.amount, 0))
+        ON CONFLICT (processor, bucket) DO UPDATE
+        SET total_requests = payment_rollups.total_requests + 1, total_amount = payment_rollups.total_amount + EXCLUDED.total_amount;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER payments_rollup_trigger
+AFTER INSERT OR DELETE OR UPDATE OF processor_used, amount, requested_at ON payments
+FOR EACH ROW EXECUTE FUNCTION payments_rollup();
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS reconciliation_runs (
+    id BIGSERIAL NOT NULL,
+    processor VARCHAR(8) NOT NULL,
+    range_from TIMESTAMP NOT NULL,
+    range_to TIMESTAMP NOT NULL,
+    local_requests BIGINT NOT NULL,
+    local_amount BIGINT NOT NULL,
+    remote_requests BIGINT NULL DEFAULT NULL,
+    remote_amount BIGINT NULL DEFAULT NULL,
+    error TEXT NULL DEFAULT NULL,
+    repaired BIGINT NOT NULL DEFAULT 0,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
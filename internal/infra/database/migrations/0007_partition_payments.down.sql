@@ -0,0 +1,37 @@
+-- archived payments are not brought back, they only exist in the archive files
+DROP TABLE IF EXISTS payment_archives;
+
+ALTER TABLE payments RENAME TO payments_partitioned;
+ALTER TABLE payments_partitioned RENAME CONSTRAINT payments_pkey TO payments_partitioned_pkey;
+ALTER TABLE payments_partitioned RENAME CONSTRAINT payments_correlation_id_key TO payments_partitioned_correlation_id_key;
+ALTER INDEX requested_at_idx RENAME TO payments_partitioned_requested_at_idx;
+ALTER INDEX pending_requested_at_idx RENAME TO payments_partitioned_pending_requested_at_idx;
+DROP TRIGGER payments_rollup_trigger ON payments_partitioned;
+ALTER SEQUENCE payments_id_seq OWNED BY NONE;
+
+CREATE TABLE payments (
+    id INTEGER NOT NULL DEFAULT nextval('payments_id_seq'),
+    correlation_id UUID NULL DEFAULT NULL,
+    amount BIGINT NULL DEFAULT NULL,
+    processor_used VARCHAR(8) NULL DEFAULT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    status VARCHAR(9) NOT NULL DEFAULT 'processed',
+    PRIMARY KEY (id),
+    CONSTRAINT payments_correlation_id_key UNIQUE (correlation_id)
+);
+
+ALTER SEQUENCE payments_id_seq OWNED BY payments.id;
+
+CREATE INDEX requested_at_idx ON payments (requested_at);
+CREATE INDEX pending_requested_at_idx ON payments (requested_at) WHERE status = 'pending';
+
+INSERT INTO payments (id, correlation_id, amount, processor_used, requested_at, status)
+SELECT id, correlation_id, amount, processor_used, requested_at, status
+FROM payments_partitioned
+ON CONFLICT (correlation_id) DO NOTHING;
+
+DROP TABLE payments_partitioned;
+
+CREATE TRIGGER payments_rollup_trigger
+AFTER INSERT OR DELETE OR UPDATE OF processor_used, amount, requested_at ON payments
+FOR EACH ROW EXECUTE FUNCTION payments_rollup();
@@ -0,0 +1,61 @@
+-- payments is rebuilt partitioned by day of requested_at. Unique keys of a partitioned table must hold the partition key,
+-- so a correlation id is unique along with its requested_at, which never changes for a payment
+ALTER TABLE payments RENAME TO payments_unpartitioned;
+ALTER TABLE payments_unpartitioned RENAME CONSTRAINT payments_pkey TO payments_unpartitioned_pkey;
+ALTER TABLE payments_unpartitioned RENAME CONSTRAINT payments_correlation_id_key TO payments_unpartitioned_correlation_id_key;
+ALTER INDEX requested_at_idx RENAME TO payments_unpartitioned_requested_at_idx;
+ALTER INDEX pending_requested_at_idx RENAME TO payments_unpartitioned_pending_requested_at_idx;
+DROP TRIGGER payments_rollup_trigger ON payments_unpartitioned;
+ALTER SEQUENCE payments_id_seq OWNED BY NONE;
+
+CREATE TABLE payments (
+    id INTEGER NOT NULL DEFAULT nextval('payments_id_seq'),
+    correlation_id UUID NULL DEFAULT NULL,
+    amount BIGINT NULL DEFAULT NULL,
+    processor_used VARCHAR(8) NULL DEFAULT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    status VARCHAR(9) NOT NULL DEFAULT 'processed',
+    PRIMARY KEY (id, requested_at),
+    CONSTRAINT payments_correlation_id_key UNIQUE (correlation_id, requested_at)
+) PARTITION BY RANGE (requested_at);
+
+ALTER SEQUENCE payments_id_seq OWNED BY payments.id;
+
+CREATE INDEX requested_at_idx ON payments (requested_at);
+CREATE INDEX pending_requested_at_idx ON payments (requested_at) WHERE status = 'pending';
+
+-- daily partitions for the payments we already have and a couple of days ahead, the partitions worker creates the next ones
+DO $$
+DECLARE
+    today DATE := (NOW() AT TIME ZONE 'UTC')::DATE;
+    day DATE;
+BEGIN
+    FOR day IN SELECT generate_series(COALESCE((SELECT MIN(requested_at)::DATE FROM payments_unpartitioned), today), today + 2, INTERVAL '1 day')::DATE LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF payments FOR VALUES FROM (%L) TO (%L)', 'payments_p' || to_char(day, 'YYYYMMDD'), day, day + 1);
+    END LOOP;
+END;
+$$;
+
+-- catches payments no daily partition was created for, so inserts never fail on a missing partition
+CREATE TABLE payments_default PARTITION OF payments DEFAULT;
+
+INSERT INTO payments (id, correlation_id, amount, processor_used, requested_at, status)
+SELECT id, correlation_id, amount, processor_used, requested_at, status
+FROM payments_unpartitioned;
+
+DROP TABLE payments_unpartitioned;
+
+-- created after the copy, rollups already hold the copied payments
+CREATE TRIGGER payments_rollup_trigger
+AFTER INSERT OR DELETE OR UPDATE OF processor_used, amount, requested_at ON payments
+FOR EACH ROW EXECUTE FUNCTION payments_rollup();
+
+CREATE TABLE IF NOT EXISTS payment_archives (
+    partition_name TEXT NOT NULL,
+    range_from TIMESTAMP NOT NULL,
+    range_to TIMESTAMP NOT NULL,
+    file TEXT NOT NULL,
+    total_payments BIGINT NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (partition_name)
+);
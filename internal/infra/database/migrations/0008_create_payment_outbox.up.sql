@@ -0,0 +1,17 @@
+-- forwarding intents written in the same transaction as their pending payment. available_at doubles as the lease of the dispatcher
+-- that claimed the intent, so it is delivered again if that dispatcher dies before marking it done
+CREATE TABLE IF NOT EXISTS payment_outbox (
+    id BIGSERIAL NOT NULL,
+    correlation_id UUID NOT NULL,
+    amount BIGINT NOT NULL,
+    requested_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NULL DEFAULT NULL,
+    available_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    done_at TIMESTAMPTZ NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_outbox_available_at_idx ON payment_outbox (available_at) WHERE done_at IS NULL;
+CREATE INDEX IF NOT EXISTS payment_outbox_done_at_idx ON payment_outbox (done_at) WHERE done_at IS NOT NULL;
@@ -0,0 +1,17 @@
+-- partitions detached but not archived yet are attached back, so their payments are not lost
+DO $$
+DECLARE
+    pending RECORD;
+BEGIN
+    FOR pending IN SELECT partition_name, range_from, range_to FROM payment_archives WHERE file IS NULL LOOP
+        EXECUTE format(
+            'ALTER TABLE payments ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+            pending.partition_name, pending.range_from, pending.range_to
+        );
+    END LOOP;
+END $$;
+
+DELETE FROM payment_archives WHERE file IS NULL;
+
+ALTER TABLE payment_archives ALTER COLUMN file SET NOT NULL;
+ALTER TABLE payment_archives ALTER COLUMN total_payments SET NOT NULL;
@@ -0,0 +1,3 @@
+-- a partition is recorded here as soon as it is detached, file and total_payments are only set once its archive is written and it is dropped
+ALTER TABLE payment_archives ALTER COLUMN file DROP NOT NULL;
+ALTER TABLE payment_archives ALTER COLUMN total_payments DROP NOT NULL;
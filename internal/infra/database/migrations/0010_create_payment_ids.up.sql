@@ -0,0 +1,21 @@
+-- payments can only be unique on correlation_id along with requested_at, its partition key. This table keeps each correlation id
+-- to a single payment, whatever the requested_at a retry or redelivery comes with
+CREATE TABLE IF NOT EXISTS payment_ids (
+    correlation_id UUID NOT NULL,
+    requested_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (correlation_id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_ids_requested_at_idx ON payment_ids (requested_at);
+
+-- duplicates recorded since payments was partitioned are collapsed into the processed one, or else the first one
+INSERT INTO payment_ids (correlation_id, requested_at)
+SELECT DISTINCT ON (correlation_id) correlation_id, requested_at
+FROM payments
+WHERE correlation_id IS NOT NULL
+ORDER BY correlation_id, status = 'processed' DESC, requested_at
+ON CONFLICT DO NOTHING;
+
+DELETE FROM payments p
+USING payment_ids i
+WHERE p.correlation_id = i.correlation_id AND p.requested_at <> i.requested_at;
@@ -0,0 +1,11 @@
+-- buffered status writes Postgres rejected, kept so they can be repaired by hand instead of being lost
+CREATE TABLE IF NOT EXISTS payment_write_rejections (
+    id SERIAL PRIMARY KEY,
+    correlation_id TEXT NOT NULL,
+    amount BIGINT NULL DEFAULT NULL,
+    processor_used TEXT NULL DEFAULT NULL,
+    requested_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL,
+    rejected_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	replicaCheckInterval = 1 * time.Second
+	replicaCheckTimeout  = 1 * time.Second
+)
+
+// lag of every replica at its last check, -1 when it could not be checked
+var replicaLagMs = expvar.NewMap("replica_lag_ms")
+
+// replica is a read-only pool, read from only while it answers within the staleness tolerance
+type replica struct {
+	host string
+	conn *pgxpool.Pool
+
+	fresh   atomic.Bool
+	checked atomic.Bool
+	lagMs   expvar.Int
+}
+
+// loadReplicas opens a pool per replica DSN, sized like the primary pool
+func loadReplicas(primary *pgxpool.Config, dsns []string, applicationName string) ([]*replica, error) {
+	var replicas []*replica
+
+	for _, dsn := range dsns {
+		cfg, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replica dsn: %w", err)
+		}
+
+		cfg.MaxConns = primary.MaxConns
+		cfg.MinConns = primary.MinConns
+		cfg.MinIdleConns = primary.MinIdleConns
+		cfg.MaxConnLifetime = primary.MaxConnLifetime
+		if _, ok := cfg.ConnConfig.RuntimeParams["application_name"]; !ok {
+			cfg.ConnConfig.RuntimeParams["application_name"] = applicationName
+		}
+
+		conn, err := pgxpool.NewWithConfig(context.Background(), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create replica connection pool: %w", err)
+		}
+
+		r := &replica{host: fmt.Sprintf("%s:%d", cfg.ConnConfig.Host, cfg.ConnConfig.Port), conn: conn}
+		replicaLagMs.Set(r.host, &r.lagMs)
+		replicas = append(replicas, r)
+	}
+
+	return replicas, nil
+}
+
+// Read returns the next replica fresh enough for the staleness tolerance, or the primary when there is none
+func (d *Db) Read() *pgxpool.Pool {
+	n := len(d.replicas)
+	if n == 0 {
+		return d.Conn
+	}
+
+	start := int(d.nextReplica.Add(1))
+	for i := range n {
+		r := d.replicas[(start+i)%n]
+		if r.fresh.Load() {
+			return r.conn
+		}
+	}
+
+	return d.Conn
+}
+
+// StartReplicaMonitor checks every replica once before returning, so none is read from before it is known to be fresh
+func (d *Db) StartReplicaMonitor() {
+	if len(d.replicas) == 0 {
+		return
+	}
+
+	d.checkReplicas()
+
+	ticker := time.NewTicker(replicaCheckInterval)
+	go func() {
+		for range ticker.C {
+			d.checkReplicas()
+		}
+	}()
+}
+
+// checkReplicas compares every replica to the WAL position of the primary
+func (d *Db) checkReplicas() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	var primaryLSN string
+	err := d.Conn.QueryRow(ctx, "SELECT pg_current_wal_lsn()::TEXT").Scan(&primaryLSN)
+
+	for _, r := range d.replicas {
+		d.checkReplica(r, primaryLSN, err)
+	}
+}
+
+// checkReplica measures the lag of the replica, none once it replayed up to the primary position since the primary may be idle
+func (d *Db) checkReplica(r *replica, primaryLSN string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	var lagMs int64
+	if err != nil {
+		err = fmt.Errorf("reading primary wal position: %w", err)
+	} else {
+		err = r.conn.QueryRow(ctx, `
+			SELECT CASE
+				WHEN NOT pg_is_in_recovery() OR pg_last_wal_replay_lsn() >= $1::PG_LSN THEN 0
+				ELSE COALESCE((EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) * 1000)::BIGINT, -1)
+			END
+		`, primaryLSN).Scan(&lagMs)
+	}
+
+	// a replica that never replayed a transaction can't be told how far behind it is
+	if err == nil && lagMs < 0 {
+		err = errors.New("no transaction replayed yet")
+	}
+
+	fresh := err == nil && time.Duration(lagMs)*time.Millisecond <= d.replicaMaxStaleness
+	if err != nil {
+		lagMs = -1
+	}
+	r.lagMs.Set(lagMs)
+
+	// only changes are logged, besides the first check
+	first := !r.checked.Swap(true)
+	if r.fresh.Swap(fresh) == fresh && !first {
+		return
+	}
+
+	if fresh {
+		slog.Info("reading from replica", "host", r.host, "lagMs", lagMs)
+		return
+	}
+
+	if err != nil {
+		slog.Error("Error checking replica, reading from the primary instead", "host", r.host, "err", err)
+		return
+	}
+
+	slog.Warn("replica too stale, reading from the primary instead", "host", r.host, "lagMs", lagMs, "maxStaleness", d.replicaMaxStaleness)
+}
+
+func (d *Db) Close() {
+	for _, r := range d.replicas {
+		r.conn.Close()
+	}
+
+	d.Conn.Close()
+}
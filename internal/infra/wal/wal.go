@@ -0,0 +1,439 @@
+package wal
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordAppend byte = 1
+	recordAck    byte = 2
+
+	// length and checksum of the body
+	recordHeaderSize = 8
+	// kind and sequence number, before the appended data
+	recordBodyHeaderSize = 9
+	maxRecordSize        = 1 << 20
+
+	segmentExt = ".wal"
+)
+
+var (
+	ErrClosed = errors.New("wal closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// WAL is an append-only log of [length][crc32c][kind][seq][data] records, fsynced in batches and kept in segments until acknowledged
+type WAL struct {
+	dir             string
+	segmentMaxBytes int64
+
+	mu        sync.Mutex
+	nextSeq   uint64
+	buf       []byte
+	bufSeqs   []uint64
+	waiters   []chan error
+	err       error
+	recovered []Entry
+
+	// only the syncer touches the file, under mu only while rotating
+	file     *os.File
+	current  *segment
+	segments []*segment
+	unacked  map[uint64]*segment
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type Entry struct {
+	Seq  uint64
+	Data []byte
+}
+
+type segment struct {
+	index   int
+	path    string
+	size    int64
+	unacked int
+}
+
+// Open reads the segments in dir, keeping what was not acknowledged for Recovered, and starts a new segment to append to
+func Open(dir string, segmentMaxBytes int64, syncInterval time.Duration) (*WAL, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:             dir,
+		segmentMaxBytes: segmentMaxBytes,
+		nextSeq:         1,
+		unacked:         make(map[uint64]*segment),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	err = w.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.rotate()
+	if err != nil {
+		return nil, err
+	}
+	w.removeAckedSegments()
+
+	go w.syncLoop(syncInterval)
+
+	return w, nil
+}
+
+// Recovered returns the entries found unacknowledged when the log was opened, oldest first
+func (w *WAL) Recovered() []Entry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.recovered
+}
+
+// Append returns the entry sequence number once the entry is on disk
+func (w *WAL) Append(data []byte) (uint64, error) {
+	if len(data) > maxRecordSize-recordBodyHeaderSize {
+		return 0, fmt.Errorf("wal entry of %d bytes is too large", len(data))
+	}
+
+	done := make(chan error, 1)
+
+	w.mu.Lock()
+	if w.err != nil {
+		w.mu.Unlock()
+		return 0, w.err
+	}
+
+	seq := w.nextSeq
+	w.nextSeq++
+	w.buf = appendRecord(w.buf, recordAppend, seq, data)
+	w.bufSeqs = append(w.bufSeqs, seq)
+	w.waiters = append(w.waiters, done)
+	w.mu.Unlock()
+
+	err := <-done
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// Ack marks the entry as no longer needed, written with the next batch
+func (w *WAL) Ack(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	seg, ok := w.unacked[seq]
+	if !ok {
+		return nil
+	}
+
+	delete(w.unacked, seq)
+	seg.unacked--
+	w.buf = appendRecord(w.buf, recordAck, seq, nil)
+
+	return nil
+}
+
+// Close writes what is still buffered and closes the current segment
+func (w *WAL) Close() error {
+	close(w.stop)
+	<-w.done
+
+	err := w.flush()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = ErrClosed
+	}
+	// appends that raced the last flush
+	w.failWaiters(w.err)
+
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (w *WAL) syncLoop(syncInterval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := w.flush()
+		if err != nil {
+			slog.Error("Error writing wal, failing appends from now on", "err", err)
+		}
+	}
+}
+
+// flush writes and fsyncs the batch, then wakes up its appends
+func (w *WAL) flush() error {
+	w.mu.Lock()
+	if w.err != nil {
+		w.mu.Unlock()
+		return nil
+	}
+
+	if w.current.size >= w.segmentMaxBytes {
+		err := w.rotate()
+		if err != nil {
+			w.err = err
+			w.failWaiters(err)
+			w.mu.Unlock()
+			return err
+		}
+	}
+
+	buf, seqs, waiters, file, seg := w.buf, w.bufSeqs, w.waiters, w.file, w.current
+	w.buf, w.bufSeqs, w.waiters = nil, nil, nil
+	w.mu.Unlock()
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	w.mu.Lock()
+	if err != nil {
+		// a partial write leaves a torn record behind, so nothing may be appended after it
+		w.err = err
+	} else {
+		seg.size += int64(len(buf))
+		seg.unacked += len(seqs)
+		for _, seq := range seqs {
+			w.unacked[seq] = seg
+		}
+
+		w.removeAckedSegments()
+	}
+	w.mu.Unlock()
+
+	for _, done := range waiters {
+		done <- err
+	}
+
+	return err
+}
+
+func (w *WAL) failWaiters(err error) {
+	for _, done := range w.waiters {
+		done <- err
+	}
+
+	w.buf, w.bufSeqs, w.waiters = nil, nil, nil
+}
+
+// rotate starts the next segment, named after the next sequence number so sequence numbers keep increasing after a restart
+func (w *WAL) rotate() error {
+	index := int(w.nextSeq)
+	if len(w.segments) > 0 {
+		index = max(index, w.segments[len(w.segments)-1].index+1)
+	}
+
+	seg := &segment{index: index, path: filepath.Join(w.dir, fmt.Sprintf("%016d%s", index, segmentExt))}
+
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if w.file != nil {
+		err = w.file.Close()
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	w.file, w.current = file, seg
+	w.segments = append(w.segments, seg)
+
+	return nil
+}
+
+// removeAckedSegments removes fully acknowledged segments in order, as acks of a segment may live in a later one
+func (w *WAL) removeAckedSegments() {
+	for len(w.segments) > 1 && w.segments[0].unacked == 0 && w.segments[0] != w.current {
+		err := os.Remove(w.segments[0].path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Error removing wal segment", "path", w.segments[0].path, "err", err)
+			return
+		}
+
+		w.segments = w.segments[1:]
+	}
+}
+
+func (w *WAL) recover() error {
+	paths, err := filepath.Glob(filepath.Join(w.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	slices.Sort(paths)
+
+	pending := make(map[uint64][]byte)
+
+	for i, path := range paths {
+		index, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), segmentExt))
+		if err != nil {
+			slog.Warn("ignoring unexpected file in wal directory", "path", path)
+			continue
+		}
+
+		seg := &segment{index: index, path: path}
+		w.segments = append(w.segments, seg)
+
+		err = w.readSegment(seg, pending, i == len(paths)-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(w.segments) > 0 {
+		w.nextSeq = max(w.nextSeq, uint64(w.segments[len(w.segments)-1].index))
+	}
+
+	for seq, data := range pending {
+		w.recovered = append(w.recovered, Entry{seq, data})
+	}
+
+	slices.SortFunc(w.recovered, func(a, b Entry) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	if len(w.recovered) > 0 {
+		slog.Info("recovered unacknowledged wal entries", "count", len(w.recovered))
+	}
+
+	return nil
+}
+
+// readSegment stops at the first torn or corrupt record, truncating the last segment there
+func (w *WAL) readSegment(seg *segment, pending map[uint64][]byte, last bool) error {
+	file, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, recordHeaderSize)
+
+	var offset int64
+	var readErr error
+
+	for {
+		_, err = io.ReadFull(r, header)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length < recordBodyHeaderSize || length > maxRecordSize {
+			readErr = fmt.Errorf("invalid record length %d", length)
+			break
+		}
+
+		body := make([]byte, length)
+		_, err = io.ReadFull(r, body)
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			readErr = errors.New("checksum mismatch")
+			break
+		}
+
+		seq := binary.LittleEndian.Uint64(body[1:9])
+		switch body[0] {
+		case recordAppend:
+			pending[seq] = body[recordBodyHeaderSize:]
+			w.unacked[seq] = seg
+			seg.unacked++
+		case recordAck:
+			if owner, ok := w.unacked[seq]; ok {
+				delete(pending, seq)
+				delete(w.unacked, seq)
+				owner.unacked--
+			}
+		}
+
+		w.nextSeq = max(w.nextSeq, seq+1)
+		offset += int64(recordHeaderSize + length)
+	}
+
+	seg.size = offset
+
+	if readErr == nil {
+		return nil
+	}
+
+	if !last {
+		slog.Error("corrupt wal segment, ignoring the rest of it", "path", seg.path, "offset", offset, "err", readErr)
+		return nil
+	}
+
+	slog.Warn("truncating torn wal record", "path", seg.path, "offset", offset, "err", readErr)
+	return file.Truncate(offset)
+}
+
+func appendRecord(buf []byte, kind byte, seq uint64, data []byte) []byte {
+	body := make([]byte, recordBodyHeaderSize, recordBodyHeaderSize+len(data))
+	body[0] = kind
+	binary.LittleEndian.PutUint64(body[1:9], seq)
+	body = append(body, data...)
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(body)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(body, crcTable))
+
+	return append(buf, body...)
+}
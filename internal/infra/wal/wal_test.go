@@ -0,0 +1,234 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testSyncInterval = time.Millisecond
+
+func openTest(t *testing.T, dir string, segmentMaxBytes int64) *WAL {
+	t.Helper()
+
+	w, err := Open(dir, segmentMaxBytes, testSyncInterval)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	return w
+}
+
+func appendTest(t *testing.T, w *WAL, data string) uint64 {
+	t.Helper()
+
+	seq, err := w.Append([]byte(data))
+	if err != nil {
+		t.Fatalf("append %q: %v", data, err)
+	}
+
+	return seq
+}
+
+func closeTest(t *testing.T, w *WAL) {
+	t.Helper()
+
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func recoveredData(w *WAL) []string {
+	var data []string
+	for _, entry := range w.Recovered() {
+		data = append(data, string(entry.Data))
+	}
+
+	return data
+}
+
+func segmentPaths(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+
+	return paths
+}
+
+func TestRecoverTornRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		torn []byte
+	}{
+		{"partial header", []byte{20, 0}},
+		{"partial body", appendRecord(nil, recordAppend, 99, []byte("torn"))[:recordHeaderSize+4]},
+		{"checksum mismatch", func() []byte {
+			record := appendRecord(nil, recordAppend, 99, []byte("torn"))
+			record[len(record)-1] ^= 0xff
+			return record
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			w := openTest(t, dir, 1<<20)
+			appendTest(t, w, "a")
+			appendTest(t, w, "b")
+			closeTest(t, w)
+
+			paths := segmentPaths(t, dir)
+			last := paths[len(paths)-1]
+
+			info, err := os.Stat(last)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write(tt.torn)
+			file.Close()
+
+			w = openTest(t, dir, 1<<20)
+			if got := recoveredData(w); !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("recovered %v, want [a b]", got)
+			}
+
+			truncated, err := os.Stat(last)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if truncated.Size() != info.Size() {
+				t.Errorf("segment is %d bytes after recovery, want it truncated to %d", truncated.Size(), info.Size())
+			}
+
+			appendTest(t, w, "c")
+			closeTest(t, w)
+
+			w = openTest(t, dir, 1<<20)
+			defer closeTest(t, w)
+
+			if got := recoveredData(w); !slices.Equal(got, []string{"a", "b", "c"}) {
+				t.Errorf("recovered %v after appending past the torn record, want [a b c]", got)
+			}
+		})
+	}
+}
+
+func TestAckInLaterSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	// every batch fills a segment, so each append lands in a segment of its own
+	w := openTest(t, dir, 1)
+	a := appendTest(t, w, "a")
+	appendTest(t, w, "b")
+	appendTest(t, w, "c")
+
+	err := w.Ack(a)
+	if err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	closeTest(t, w)
+
+	w = openTest(t, dir, 1)
+	defer closeTest(t, w)
+
+	if got := recoveredData(w); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("recovered %v, want [b c]", got)
+	}
+}
+
+func TestSegmentRemovedOnceFullyAcked(t *testing.T) {
+	dir := t.TempDir()
+
+	w := openTest(t, dir, 1<<20)
+	a := appendTest(t, w, "a")
+	b := appendTest(t, w, "b")
+	closeTest(t, w)
+
+	first := segmentPaths(t, dir)[0]
+
+	tests := []struct {
+		name      string
+		ack       uint64
+		recovered []string
+		removed   bool
+	}{
+		{"one entry acked", a, []string{"a", "b"}, false},
+		{"every entry acked", b, []string{"b"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := openTest(t, dir, 1<<20)
+
+			if got := recoveredData(w); !slices.Equal(got, tt.recovered) {
+				t.Errorf("recovered %v, want %v", got, tt.recovered)
+			}
+
+			err := w.Ack(tt.ack)
+			if err != nil {
+				t.Fatalf("ack: %v", err)
+			}
+			closeTest(t, w)
+
+			_, err = os.Stat(first)
+			if removed := os.IsNotExist(err); removed != tt.removed {
+				t.Errorf("segment removed = %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestSeqIncreasesAfterSegmentsRemoved(t *testing.T) {
+	dir := t.TempDir()
+
+	var last uint64
+	for i := range 4 {
+		// a run appending nothing leaves only an empty segment behind, once it removed the acked ones
+		closeTest(t, openTest(t, dir, 1<<20))
+
+		w := openTest(t, dir, 1<<20)
+
+		if got := w.Recovered(); len(got) != 0 {
+			t.Fatalf("run %d: recovered %v, want nothing", i, got)
+		}
+
+		seq := appendTest(t, w, "a")
+		if seq <= last {
+			t.Errorf("run %d: appended seq %d, want it past %d", i, seq, last)
+		}
+		last = seq
+
+		err := w.Ack(seq)
+		if err != nil {
+			t.Fatalf("ack: %v", err)
+		}
+		closeTest(t, w)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	w := openTest(t, t.TempDir(), 1<<20)
+	seq := appendTest(t, w, "a")
+	closeTest(t, w)
+
+	if _, err := w.Append([]byte("b")); err != ErrClosed {
+		t.Errorf("append after close returned %v, want ErrClosed", err)
+	}
+
+	if err := w.Ack(seq); err != ErrClosed {
+		t.Errorf("ack after close returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,334 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
+)
+
+const aggregatePruneInterval = 1 * time.Minute
+
+var ErrAggregatesPruned = errors.New("range reaches payments dropped from the aggregates past retention")
+
+// PeerAggregates reads and purges the aggregates every other instance keeps
+type PeerAggregates interface {
+	Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error)
+	Purge(ctx context.Context, filter entities.PaymentFilter) error
+}
+
+// AggregatingPaymentStore answers Summary from the counters of this instance summed with those of its peers
+type AggregatingPaymentStore struct {
+	PaymentStore
+
+	aggregates *PaymentAggregates
+	peers      PeerAggregates
+}
+
+// PaymentAggregates holds exact totals of processed payments per processor and millisecond, rebuilt from its WAL on startup
+type PaymentAggregates struct {
+	log       *wal.WAL
+	retention time.Duration
+
+	mu sync.RWMutex
+	// buckets of each processor, sorted by time
+	buckets map[string][]aggregateBucket
+	// payments counted in the buckets kept, so recording one twice doesn't count it twice
+	counted map[string]countedPayment
+	// buckets before this time, in milliseconds, were dropped. 0 until a bucket is
+	prunedBefore int64
+	prunedAt     time.Time
+}
+
+type aggregateBucket struct {
+	at     int64
+	totals entities.PaymentTotals
+	// WAL entries of the payments counted in the bucket
+	seqs []uint64
+}
+
+type countedPayment struct {
+	at        int64
+	processor string
+}
+
+// aggregateEntry is a counted payment as kept in the WAL
+type aggregateEntry struct {
+	CorrelationId string `json:"correlationId"`
+	Processor     string `json:"processor"`
+	AmountCents   int64  `json:"amountCents"`
+	At            int64  `json:"at"`
+}
+
+func NewAggregatingPaymentStore(store PaymentStore, aggregates *PaymentAggregates, peers PeerAggregates) *AggregatingPaymentStore {
+	return &AggregatingPaymentStore{
+		PaymentStore: store,
+		aggregates:   aggregates,
+		peers:        peers,
+	}
+}
+
+// NewPaymentAggregates counts again the payments left in the log
+func NewPaymentAggregates(log *wal.WAL, retention time.Duration) *PaymentAggregates {
+	a := &PaymentAggregates{
+		log:       log,
+		retention: retention,
+		buckets:   make(map[string][]aggregateBucket),
+		counted:   make(map[string]countedPayment),
+	}
+
+	if log == nil {
+		return a
+	}
+
+	for _, recovered := range log.Recovered() {
+		var entry aggregateEntry
+
+		err := json.Unmarshal(recovered.Data, &entry)
+		if err != nil {
+			slog.Error("Error decoding counted payment, dropping it", "seq", recovered.Seq, "err", err)
+			log.Ack(recovered.Seq)
+			continue
+		}
+
+		a.count(&entry, recovered.Seq)
+	}
+
+	a.prune(time.Now())
+	return a
+}
+
+// MarkProcessed counts the payment even when the underlying store fails to record it, since the processor has it either way
+func (a *AggregatingPaymentStore) MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error {
+	a.aggregates.Record(p, processorUsed)
+	return a.PaymentStore.MarkProcessed(ctx, p, processorUsed)
+}
+
+func (a *AggregatingPaymentStore) Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	summary, err := a.aggregates.Summary(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	peerSummary, err := a.peers.Summary(ctx, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	for processor, peerTotals := range peerSummary {
+		totals := summary[processor]
+		totals.TotalRequests += peerTotals.TotalRequests
+		totals.AmountCents += peerTotals.AmountCents
+		summary[processor] = totals
+	}
+
+	return summary, nil
+}
+
+// Purge drops the matching counters of every instance along with the payments, even if the store fails
+func (a *AggregatingPaymentStore) Purge(ctx context.Context, filter entities.PaymentFilter) (int64, error) {
+	a.aggregates.Purge(filter)
+	peersErr := a.peers.Purge(ctx, filter)
+
+	deleted, err := a.PaymentStore.Purge(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, peersErr
+}
+
+// Record counts a processed payment once, however many times it is recorded
+func (a *PaymentAggregates) Record(p *entities.Payment, processor string) {
+	entry := aggregateEntry{
+		CorrelationId: p.CorrelationId,
+		Processor:     processor,
+		AmountCents:   int64(math.Round(p.Amount * 100)),
+		At:            p.RequestedAt.UnixMilli(),
+	}
+
+	a.mu.RLock()
+	_, counted := a.counted[p.CorrelationId]
+	a.mu.RUnlock()
+
+	if counted {
+		return
+	}
+
+	var seq uint64
+	if a.log != nil {
+		data, _ := json.Marshal(&entry)
+
+		var err error
+		seq, err = a.log.Append(data)
+		if err != nil {
+			slog.Error("Error logging counted payment", "correlationId", p.CorrelationId, "err", err)
+		}
+	}
+
+	if !a.count(&entry, seq) && seq != 0 {
+		a.log.Ack(seq)
+	}
+
+	a.prune(time.Now())
+}
+
+// count returns false when the payment was counted already
+func (a *PaymentAggregates) count(entry *aggregateEntry, seq uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.counted[entry.CorrelationId]; ok {
+		return false
+	}
+	a.counted[entry.CorrelationId] = countedPayment{entry.At, entry.Processor}
+
+	// payments mostly arrive in time order, so a new bucket is almost always appended
+	buckets := a.buckets[entry.Processor]
+	i, found := slices.BinarySearchFunc(buckets, entry.At, compareAggregateBucket)
+	if !found {
+		buckets = slices.Insert(buckets, i, aggregateBucket{at: entry.At})
+	}
+
+	buckets[i].totals.TotalRequests++
+	buckets[i].totals.AmountCents += entry.AmountCents
+	if seq != 0 {
+		buckets[i].seqs = append(buckets[i].seqs, seq)
+	}
+	a.buckets[entry.Processor] = buckets
+
+	return true
+}
+
+// Summary fails with ErrAggregatesPruned when the range starts before buckets dropped past retention
+func (a *PaymentAggregates) Summary(tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if tr.From != nil && tr.From.UnixMilli() < a.prunedBefore {
+		return nil, ErrAggregatesPruned
+	}
+
+	summary := make(map[string]entities.PaymentTotals)
+	for processor, buckets := range a.buckets {
+		from, to := aggregateBucketRange(buckets, tr)
+
+		var totals entities.PaymentTotals
+		for _, bucket := range buckets[from:to] {
+			totals.TotalRequests += bucket.totals.TotalRequests
+			totals.AmountCents += bucket.totals.AmountCents
+		}
+
+		if totals.TotalRequests > 0 {
+			summary[processor] = totals
+		}
+	}
+
+	return summary, nil
+}
+
+// Purge drops the counted payments in the time range and processor of the filter
+func (a *PaymentAggregates) Purge(filter entities.PaymentFilter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for processor, buckets := range a.buckets {
+		if filter.Processor != "" && filter.Processor != processor {
+			continue
+		}
+
+		from, to := aggregateBucketRange(buckets, filter.TimeRange)
+		a.ack(buckets[from:to])
+		a.buckets[processor] = slices.Delete(buckets, from, to)
+	}
+
+	for correlationId, payment := range a.counted {
+		if filter.Processor != "" && filter.Processor != payment.processor {
+			continue
+		}
+
+		if filter.From != nil && payment.at < filter.From.UnixMilli() || filter.To != nil && payment.at > filter.To.UnixMilli() {
+			continue
+		}
+
+		delete(a.counted, correlationId)
+	}
+}
+
+// prune drops the buckets past retention along with their log entries, and forgets the payments counted in them
+func (a *PaymentAggregates) prune(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.retention <= 0 || now.Sub(a.prunedAt) < aggregatePruneInterval {
+		return
+	}
+	a.prunedAt = now
+
+	cutoff := now.Add(-a.retention).UnixMilli()
+	dropped := false
+
+	for processor, buckets := range a.buckets {
+		to, _ := slices.BinarySearchFunc(buckets, cutoff, compareAggregateBucket)
+		if to == 0 {
+			continue
+		}
+
+		a.ack(buckets[:to])
+		a.buckets[processor] = slices.Delete(buckets, 0, to)
+		dropped = true
+	}
+
+	for correlationId, payment := range a.counted {
+		if payment.at < cutoff {
+			delete(a.counted, correlationId)
+		}
+	}
+
+	if dropped {
+		a.prunedBefore = max(a.prunedBefore, cutoff)
+	}
+}
+
+func (a *PaymentAggregates) ack(buckets []aggregateBucket) {
+	if a.log == nil {
+		return
+	}
+
+	for _, bucket := range buckets {
+		for _, seq := range bucket.seqs {
+			err := a.log.Ack(seq)
+			if err != nil {
+				slog.Error("Error acknowledging counted payment", "seq", seq, "err", err)
+				return
+			}
+		}
+	}
+}
+
+// aggregateBucketRange returns the bounds of the buckets inside the inclusive time range
+func aggregateBucketRange(buckets []aggregateBucket, tr entities.TimeRange) (int, int) {
+	from, to := 0, len(buckets)
+
+	if tr.From != nil {
+		from, _ = slices.BinarySearchFunc(buckets, tr.From.UnixMilli(), compareAggregateBucket)
+	}
+
+	if tr.To != nil {
+		to, _ = slices.BinarySearchFunc(buckets, tr.To.Add(time.Millisecond).UnixMilli(), compareAggregateBucket)
+	}
+
+	return from, max(from, to)
+}
+
+func compareAggregateBucket(bucket aggregateBucket, at int64) int {
+	return cmp.Compare(bucket.at, at)
+}
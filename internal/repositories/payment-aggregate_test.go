@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+func TestPaymentAggregatesDedup(t *testing.T) {
+	type record struct {
+		correlationId string
+		processor     string
+	}
+
+	tests := []struct {
+		name    string
+		records []record
+		want    map[string]entities.PaymentTotals
+	}{
+		{"once", []record{{"a", "default"}}, map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 1000}}},
+		{"twice", []record{{"a", "default"}, {"a", "default"}}, map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 1000}}},
+		{"twice on different processors", []record{{"a", "default"}, {"a", "fallback"}}, map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 1000}}},
+		{"different payments", []record{{"a", "default"}, {"b", "default"}, {"c", "fallback"}}, map[string]entities.PaymentTotals{"default": {TotalRequests: 2, AmountCents: 2000}, "fallback": {TotalRequests: 1, AmountCents: 1000}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewPaymentAggregates(nil, 0)
+
+			for _, r := range tt.records {
+				a.Record(testPayment(r.correlationId, 10, 0), r.processor)
+			}
+
+			got, err := a.Summary(entities.TimeRange{})
+			if err != nil {
+				t.Fatalf("summary: %v", err)
+			}
+
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentAggregatesPrune(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	at := func(offset time.Duration) *time.Time {
+		t := now.Add(offset)
+		return &t
+	}
+
+	tests := []struct {
+		name string
+		// prune once past the retention of the old payment
+		prune      bool
+		tr         entities.TimeRange
+		want       map[string]entities.PaymentTotals
+		wantPruned bool
+	}{
+		{"nothing dropped yet", false, entities.TimeRange{From: at(-3 * time.Hour)}, map[string]entities.PaymentTotals{"default": {TotalRequests: 2, AmountCents: 3000}}, false},
+		{"range before the dropped buckets", true, entities.TimeRange{From: at(-3 * time.Hour)}, nil, true},
+		{"open start answered from the buckets kept", true, entities.TimeRange{}, map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 2000}}, false},
+		{"range after the dropped buckets", true, entities.TimeRange{From: at(-time.Minute)}, map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 2000}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewPaymentAggregates(nil, time.Hour)
+			a.Record(&entities.Payment{CorrelationId: "old", Amount: 10, RequestedAt: now.Add(-50 * time.Minute)}, "default")
+			a.Record(&entities.Payment{CorrelationId: "new", Amount: 20, RequestedAt: now.Add(-time.Second)}, "default")
+
+			if tt.prune {
+				a.prune(now.Add(15 * time.Minute))
+
+				// the dropped payment is forgotten along with its bucket
+				if _, ok := a.counted["old"]; ok {
+					t.Error("dropped payment still counted")
+				}
+			}
+
+			got, err := a.Summary(tt.tr)
+			if pruned := errors.Is(err, ErrAggregatesPruned); pruned != tt.wantPruned {
+				t.Fatalf("got error %v, want pruned = %v", err, tt.wantPruned)
+			}
+
+			if !tt.wantPruned && !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
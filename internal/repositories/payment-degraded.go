@@ -0,0 +1,284 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
+)
+
+const (
+	degradedProbeInterval = 1 * time.Second
+	degradedProbeTimeout  = 5 * time.Second
+	degradedReplayTimeout = 30 * time.Second
+)
+
+var ErrDegradedLogFull = errors.New("database unavailable and degraded write log full")
+
+// DegradedPaymentStore logs status writes while the underlying store is unreachable, and replays them in order once it is back
+type DegradedPaymentStore struct {
+	PaymentStore
+
+	// probe tells whether the underlying store can take writes again
+	probe      func(ctx context.Context) error
+	maxEntries int
+	writeLog   *wal.WAL
+
+	log    []loggedWrite
+	logMu  sync.Mutex
+	replay sync.Mutex
+
+	available     atomic.Bool
+	degradedSince atomic.Int64
+}
+
+type loggedWrite struct {
+	statusWrite
+	// WAL entry of the write, 0 when it could not be written to the WAL
+	seq uint64
+}
+
+// loggedWriteEntry is a logged write as kept in the WAL
+type loggedWriteEntry struct {
+	Payment       entities.Payment `json:"payment"`
+	ProcessorUsed *string          `json:"processorUsed"`
+	Status        string           `json:"status"`
+}
+
+type DegradedStatus struct {
+	Available      bool
+	DegradedSince  *time.Time
+	BufferedWrites int
+	LogFull        bool
+}
+
+// NewDegradedPaymentStore logs again the writes left in writeLog by the last run
+func NewDegradedPaymentStore(store PaymentStore, maxEntries int, probe func(ctx context.Context) error, writeLog *wal.WAL) *DegradedPaymentStore {
+	d := &DegradedPaymentStore{
+		PaymentStore: store,
+		probe:        probe,
+		maxEntries:   maxEntries,
+		writeLog:     writeLog,
+	}
+
+	if writeLog == nil {
+		return d
+	}
+
+	for _, recovered := range writeLog.Recovered() {
+		var entry loggedWriteEntry
+
+		err := json.Unmarshal(recovered.Data, &entry)
+		if err != nil {
+			slog.Error("Error decoding logged payment write, dropping it", "seq", recovered.Seq, "err", err)
+			d.ack(recovered.Seq)
+			continue
+		}
+
+		d.log = append(d.log, loggedWrite{statusWrite{entry.Payment, entry.ProcessorUsed, entry.Status, nil}, recovered.Seq})
+	}
+
+	if len(d.log) > 0 {
+		slog.Warn("recovered logged payment writes from the last run", "count", len(d.log))
+	}
+
+	return d
+}
+
+// StartMonitor probes the underlying store once before returning, so the store starts out degraded if it is down already
+func (d *DegradedPaymentStore) StartMonitor() {
+	d.checkAvailability()
+
+	ticker := time.NewTicker(degradedProbeInterval)
+	go func() {
+		for range ticker.C {
+			d.checkAvailability()
+		}
+	}()
+}
+
+func (d *DegradedPaymentStore) Status() DegradedStatus {
+	d.logMu.Lock()
+	buffered := len(d.log)
+	d.logMu.Unlock()
+
+	status := DegradedStatus{
+		Available:      d.available.Load(),
+		BufferedWrites: buffered,
+		LogFull:        buffered >= d.maxEntries,
+	}
+
+	if since := d.degradedSince.Load(); since != 0 && !status.Available {
+		t := time.UnixMilli(since).UTC()
+		status.DegradedSince = &t
+	}
+
+	return status
+}
+
+func (d *DegradedPaymentStore) CreatePending(ctx context.Context, p *entities.Payment) error {
+	return d.write(ctx, statusWrite{*p, nil, entities.PaymentStatusPending, nil})
+}
+
+func (d *DegradedPaymentStore) MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error {
+	return d.write(ctx, statusWrite{*p, &processorUsed, entities.PaymentStatusProcessed, nil})
+}
+
+func (d *DegradedPaymentStore) MarkParked(ctx context.Context, p *entities.Payment) error {
+	return d.write(ctx, statusWrite{*p, nil, entities.PaymentStatusParked, nil})
+}
+
+// write goes straight to the underlying store unless writes are being logged already, which keeps them in order
+func (d *DegradedPaymentStore) write(ctx context.Context, write statusWrite) error {
+	d.logMu.Lock()
+	logging := len(d.log) > 0
+	d.logMu.Unlock()
+
+	if d.available.Load() && !logging {
+		err := d.apply(ctx, write)
+		if !isUnavailableErr(err) {
+			return err
+		}
+
+		d.markUnavailable(err)
+	}
+
+	d.logMu.Lock()
+	full := len(d.log) >= d.maxEntries
+	d.logMu.Unlock()
+
+	if full {
+		return ErrDegradedLogFull
+	}
+
+	// writes of a payment come one after the other, so the WAL keeps them in order just like the log
+	var seq uint64
+	if d.writeLog != nil {
+		data, _ := json.Marshal(&loggedWriteEntry{write.payment, write.processorUsed, write.status})
+
+		var err error
+		seq, err = d.writeLog.Append(data)
+		if err != nil {
+			slog.Error("Error writing logged payment write to the wal, it is lost on restart", "correlationId", write.payment.CorrelationId, "err", err)
+		} else {
+			onDurable(ctx)()
+		}
+	}
+
+	d.logMu.Lock()
+	d.log = append(d.log, loggedWrite{write, seq})
+	d.logMu.Unlock()
+
+	return nil
+}
+
+func (d *DegradedPaymentStore) apply(ctx context.Context, write statusWrite) error {
+	switch write.status {
+	case entities.PaymentStatusProcessed:
+		return d.PaymentStore.MarkProcessed(ctx, &write.payment, *write.processorUsed)
+	case entities.PaymentStatusParked:
+		return d.PaymentStore.MarkParked(ctx, &write.payment)
+	default:
+		return d.PaymentStore.CreatePending(ctx, &write.payment)
+	}
+}
+
+func (d *DegradedPaymentStore) checkAvailability() {
+	ctx, cancel := context.WithTimeout(context.Background(), degradedProbeTimeout)
+	defer cancel()
+
+	err := d.probe(ctx)
+	if err != nil {
+		d.markUnavailable(err)
+		return
+	}
+
+	if !d.available.Swap(true) {
+		slog.Info("payment store available again", "degradedSince", time.UnixMilli(d.degradedSince.Load()).UTC())
+	}
+
+	d.replayLog()
+}
+
+func (d *DegradedPaymentStore) markUnavailable(err error) {
+	if d.available.Swap(false) || d.degradedSince.Load() == 0 {
+		d.degradedSince.Store(time.Now().UnixMilli())
+		slog.Error("payment store unavailable, logging writes locally", "err", err)
+	}
+}
+
+// replayLog applies the logged writes oldest first, along with those logged meanwhile
+func (d *DegradedPaymentStore) replayLog() {
+	d.replay.Lock()
+	defer d.replay.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), degradedReplayTimeout)
+	defer cancel()
+
+	replayed := 0
+	for {
+		d.logMu.Lock()
+		if len(d.log) == 0 {
+			d.logMu.Unlock()
+			break
+		}
+		write := d.log[0]
+		d.logMu.Unlock()
+
+		err := d.apply(ctx, write.statusWrite)
+		if isUnavailableErr(err) {
+			d.markUnavailable(err)
+			break
+		}
+
+		// the store answered, retrying won't change its mind
+		if err != nil {
+			slog.Error("Error replaying logged payment write, dropping it", "correlationId", write.payment.CorrelationId, "status", write.status, "err", err)
+		}
+
+		d.logMu.Lock()
+		d.log = d.log[1:]
+		d.logMu.Unlock()
+		d.ack(write.seq)
+		replayed++
+	}
+
+	if replayed > 0 {
+		slog.Info("replayed logged payment writes", "count", replayed)
+	}
+}
+
+func (d *DegradedPaymentStore) ack(seq uint64) {
+	if d.writeLog == nil || seq == 0 {
+		return
+	}
+
+	// once closed on shutdown, the write is only replayed again on the next start
+	err := d.writeLog.Ack(seq)
+	if err != nil && !errors.Is(err, wal.ErrClosed) {
+		slog.Error("Error acknowledging logged payment write", "seq", seq, "err", err)
+	}
+}
+
+// isUnavailableErr tells apart failing to reach the database from the database rejecting the write
+func isUnavailableErr(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exceptions, and the server shutting down or still starting
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P03"
+	}
+
+	return true
+}
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+const exportFetchSize = 1000
+
+// Export streams the payments in the range to fn in requested_at order through a server-side cursor, stopping at the first error of fn
+func (r *PaymentRepository) Export(ctx context.Context, tr entities.TimeRange, fn func(*entities.PaymentRecord) error) error {
+	return r.export(ctx, "payments", tr, fn)
+}
+
+// ExportPartition streams every payment of a detached partition to fn, read from the primary as replicas may not have replayed the detach yet
+func (r *PaymentRepository) ExportPartition(ctx context.Context, partition entities.PaymentPartition, fn func(*entities.PaymentRecord) error) error {
+	return r.export(WithPrimaryReads(ctx), pgx.Identifier{partition.Name}.Sanitize(), entities.TimeRange{}, fn)
+}
+
+func (r *PaymentRepository) export(ctx context.Context, table string, tr entities.TimeRange, fn func(*entities.PaymentRecord) error) error {
+	// cursors only live inside a transaction, a savepoint when the repository is in one already
+	var tx pgx.Tx
+	var err error
+	if r.tx != nil {
+		tx, err = r.tx.Begin(ctx)
+	} else {
+		tx, err = r.readPool(ctx).BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	conditions, args := timeRangeConditions(tr, "requested_at", nil)
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	// DECLARE can't take bound parameters, so they are interpolated by pgx
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+		DECLARE payments_export NO SCROLL CURSOR FOR
+		SELECT id, correlation_id::TEXT, COALESCE(amount, 0), processor_used, status, requested_at
+		FROM %s
+		WHERE %s
+		ORDER BY requested_at, id
+	`, table, where), append([]any{pgx.QueryExecModeSimpleProtocol}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		fetched, err := r.fetchExportBatch(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if fetched < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+func (r *PaymentRepository) fetchExportBatch(ctx context.Context, tx pgx.Tx, fn func(*entities.PaymentRecord) error) (int, error) {
+	res, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM payments_export", exportFetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	fetched := 0
+	for res.Next() {
+		var record entities.PaymentRecord
+
+		err = res.Scan(&record.Id, &record.CorrelationId, &record.AmountCents, &record.Processor, &record.Status, &record.RequestedAt)
+		if err != nil {
+			return fetched, err
+		}
+
+		err = fn(&record)
+		if err != nil {
+			return fetched, err
+		}
+
+		fetched++
+	}
+
+	return fetched, res.Err()
+}
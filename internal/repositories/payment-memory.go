@@ -0,0 +1,241 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+// MemoryPaymentStore keeps payments in the process memory only, for demos and tests
+type MemoryPaymentStore struct {
+	mu       sync.RWMutex
+	nextId   int64
+	payments map[string]*entities.PaymentRecord
+}
+
+func NewMemoryPaymentStore() *MemoryPaymentStore {
+	return &MemoryPaymentStore{payments: make(map[string]*entities.PaymentRecord)}
+}
+
+func (m *MemoryPaymentStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryPaymentStore) CreatePending(ctx context.Context, p *entities.Payment) error {
+	m.upsertStatus(p, nil, entities.PaymentStatusPending)
+	onDurable(ctx)()
+
+	return nil
+}
+
+func (m *MemoryPaymentStore) MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error {
+	m.upsertStatus(p, &processorUsed, entities.PaymentStatusProcessed)
+	onDurable(ctx)()
+
+	return nil
+}
+
+func (m *MemoryPaymentStore) MarkParked(ctx context.Context, p *entities.Payment) error {
+	m.upsertStatus(p, nil, entities.PaymentStatusParked)
+	onDurable(ctx)()
+
+	return nil
+}
+
+func (m *MemoryPaymentStore) Get(ctx context.Context, correlationId string) (*entities.PaymentRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	record, ok := m.payments[correlationId]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+
+	copied := *record
+	return &copied, nil
+}
+
+func (m *MemoryPaymentStore) PendingCount(ctx context.Context, to time.Time) (int64, error) {
+	pending := m.matching(entities.PaymentFilter{TimeRange: entities.TimeRange{To: &to}, Status: entities.PaymentStatusPending})
+	return int64(len(pending)), nil
+}
+
+func (m *MemoryPaymentStore) PendingPayments(ctx context.Context, tr entities.TimeRange, limit int) ([]entities.Payment, error) {
+	var payments []entities.Payment
+
+	for _, record := range m.matching(entities.PaymentFilter{TimeRange: tr, Status: entities.PaymentStatusPending}) {
+		if len(payments) == limit {
+			break
+		}
+
+		payments = append(payments, entities.Payment{
+			CorrelationId: record.CorrelationId,
+			Amount:        record.Amount(),
+			RequestedAt:   record.RequestedAt,
+		})
+	}
+
+	return payments, nil
+}
+
+func (m *MemoryPaymentStore) ProcessedCorrelationIds(ctx context.Context, processor string, tr entities.TimeRange, limit int) ([]string, error) {
+	var correlationIds []string
+
+	for _, record := range m.matching(entities.PaymentFilter{TimeRange: tr, Processor: processor}) {
+		if len(correlationIds) == limit {
+			break
+		}
+
+		correlationIds = append(correlationIds, record.CorrelationId)
+	}
+
+	return correlationIds, nil
+}
+
+func (m *MemoryPaymentStore) Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	summary := make(map[string]entities.PaymentTotals)
+
+	for _, record := range m.matching(entities.PaymentFilter{TimeRange: tr, Status: entities.PaymentStatusProcessed}) {
+		totals := summary[*record.Processor]
+		totals.TotalRequests++
+		totals.AmountCents += record.AmountCents
+		summary[*record.Processor] = totals
+	}
+
+	return summary, nil
+}
+
+func (m *MemoryPaymentStore) SummaryBuckets(ctx context.Context, tr entities.TimeRange, interval time.Duration) ([]entities.PaymentBucket, error) {
+	var buckets []entities.PaymentBucket
+
+	for _, record := range m.matching(entities.PaymentFilter{TimeRange: tr, Status: entities.PaymentStatusProcessed}) {
+		start := record.RequestedAt.Truncate(interval)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+			buckets = append(buckets, entities.PaymentBucket{Start: start, Processors: make(map[string]entities.PaymentTotals)})
+		}
+
+		bucket := buckets[len(buckets)-1]
+		totals := bucket.Processors[*record.Processor]
+		totals.TotalRequests++
+		totals.AmountCents += record.AmountCents
+		bucket.Processors[*record.Processor] = totals
+	}
+
+	return buckets, nil
+}
+
+func (m *MemoryPaymentStore) Search(ctx context.Context, filter entities.PaymentFilter, after *entities.PaymentCursor, limit int) ([]entities.PaymentRecord, error) {
+	records := make([]entities.PaymentRecord, 0, limit)
+
+	for _, record := range m.matching(filter) {
+		if len(records) == limit {
+			break
+		}
+
+		if after != nil && comparePaymentCursor(record, after) <= 0 {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (m *MemoryPaymentStore) Export(ctx context.Context, tr entities.TimeRange, fn func(*entities.PaymentRecord) error) error {
+	for _, record := range m.matching(entities.PaymentFilter{TimeRange: tr}) {
+		err := fn(&record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryPaymentStore) Purge(ctx context.Context, filter entities.PaymentFilter) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for correlationId, record := range m.payments {
+		if matchesPaymentFilter(record, &filter) {
+			delete(m.payments, correlationId)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *MemoryPaymentStore) upsertStatus(p *entities.Payment, processorUsed *string, status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.payments[p.CorrelationId]
+	if !ok {
+		m.nextId++
+		record = &entities.PaymentRecord{
+			Id:            m.nextId,
+			CorrelationId: p.CorrelationId,
+			AmountCents:   int64(math.Round(p.Amount * 100)),
+			RequestedAt:   p.RequestedAt.UTC().Truncate(time.Millisecond),
+		}
+		m.payments[p.CorrelationId] = record
+	} else if record.Status == entities.PaymentStatusProcessed {
+		return
+	}
+
+	record.Processor = processorUsed
+	record.Status = status
+}
+
+// matching returns copies of the payments matching the filter, in (requested_at, id) order
+func (m *MemoryPaymentStore) matching(filter entities.PaymentFilter) []entities.PaymentRecord {
+	m.mu.RLock()
+	records := make([]entities.PaymentRecord, 0, len(m.payments))
+	for _, record := range m.payments {
+		if matchesPaymentFilter(record, &filter) {
+			records = append(records, *record)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(records, func(a, b entities.PaymentRecord) int {
+		return comparePaymentCursor(a, &entities.PaymentCursor{RequestedAt: b.RequestedAt, Id: b.Id})
+	})
+
+	return records
+}
+
+func matchesPaymentFilter(record *entities.PaymentRecord, filter *entities.PaymentFilter) bool {
+	switch {
+	case filter.From != nil && record.RequestedAt.Before(*filter.From):
+		return false
+	case filter.To != nil && record.RequestedAt.After(*filter.To):
+		return false
+	case filter.Processor != "" && (record.Processor == nil || *record.Processor != filter.Processor):
+		return false
+	case filter.Status != "" && record.Status != filter.Status:
+		return false
+	case filter.MinAmountCents != nil && record.AmountCents < *filter.MinAmountCents:
+		return false
+	case filter.MaxAmountCents != nil && record.AmountCents > *filter.MaxAmountCents:
+		return false
+	default:
+		return true
+	}
+}
+
+func comparePaymentCursor(record entities.PaymentRecord, cursor *entities.PaymentCursor) int {
+	if c := record.RequestedAt.Compare(cursor.RequestedAt); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(record.Id, cursor.Id)
+}
@@ -0,0 +1,272 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+var testStart = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func testPayment(correlationId string, amount float64, offset time.Duration) *entities.Payment {
+	return &entities.Payment{CorrelationId: correlationId, Amount: amount, RequestedAt: testStart.Add(offset)}
+}
+
+func testTime(offset time.Duration) *time.Time {
+	t := testStart.Add(offset)
+	return &t
+}
+
+// seedMemoryStore processes a and b on default, c on fallback, leaves d pending and parks e, one second apart
+func seedMemoryStore(t *testing.T) *MemoryPaymentStore {
+	t.Helper()
+	ctx := context.Background()
+
+	m := NewMemoryPaymentStore()
+	m.MarkProcessed(ctx, testPayment("a", 10, 0), "default")
+	m.MarkProcessed(ctx, testPayment("b", 20.5, time.Second), "default")
+	m.MarkProcessed(ctx, testPayment("c", 30, 2*time.Second), "fallback")
+	m.CreatePending(ctx, testPayment("d", 40, 3*time.Second))
+	m.MarkParked(ctx, testPayment("e", 50, 4*time.Second))
+
+	return m
+}
+
+func correlationIds(records []entities.PaymentRecord) []string {
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.CorrelationId)
+	}
+
+	return ids
+}
+
+func TestMemoryPaymentStoreTransitions(t *testing.T) {
+	type transition struct {
+		status    string
+		processor string
+	}
+
+	tests := []struct {
+		name          string
+		transitions   []transition
+		wantStatus    string
+		wantProcessor string
+	}{
+		{"pending", []transition{{entities.PaymentStatusPending, ""}}, entities.PaymentStatusPending, ""},
+		{"pending then processed", []transition{{entities.PaymentStatusPending, ""}, {entities.PaymentStatusProcessed, "default"}}, entities.PaymentStatusProcessed, "default"},
+		{"pending then parked", []transition{{entities.PaymentStatusPending, ""}, {entities.PaymentStatusParked, ""}}, entities.PaymentStatusParked, ""},
+		{"parked then processed", []transition{{entities.PaymentStatusParked, ""}, {entities.PaymentStatusProcessed, "fallback"}}, entities.PaymentStatusProcessed, "fallback"},
+		{"processed then parked", []transition{{entities.PaymentStatusProcessed, "default"}, {entities.PaymentStatusParked, ""}}, entities.PaymentStatusProcessed, "default"},
+		{"processed then pending", []transition{{entities.PaymentStatusProcessed, "default"}, {entities.PaymentStatusPending, ""}}, entities.PaymentStatusProcessed, "default"},
+		{"processed twice", []transition{{entities.PaymentStatusProcessed, "default"}, {entities.PaymentStatusProcessed, "fallback"}}, entities.PaymentStatusProcessed, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMemoryPaymentStore()
+			p := testPayment("a", 19.9, 0)
+
+			for _, tr := range tt.transitions {
+				var err error
+				switch tr.status {
+				case entities.PaymentStatusProcessed:
+					err = m.MarkProcessed(ctx, p, tr.processor)
+				case entities.PaymentStatusParked:
+					err = m.MarkParked(ctx, p)
+				default:
+					err = m.CreatePending(ctx, p)
+				}
+
+				if err != nil {
+					t.Fatalf("%s: %v", tr.status, err)
+				}
+			}
+
+			record, err := m.Get(ctx, "a")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+
+			if record.Status != tt.wantStatus {
+				t.Errorf("status %q, want %q", record.Status, tt.wantStatus)
+			}
+
+			processor := ""
+			if record.Processor != nil {
+				processor = *record.Processor
+			}
+			if processor != tt.wantProcessor {
+				t.Errorf("processor %q, want %q", processor, tt.wantProcessor)
+			}
+
+			if record.AmountCents != 1990 {
+				t.Errorf("amount %d cents, want 1990", record.AmountCents)
+			}
+		})
+	}
+}
+
+func TestMemoryPaymentStoreGetNotFound(t *testing.T) {
+	_, err := NewMemoryPaymentStore().Get(context.Background(), "missing")
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("got %v, want ErrPaymentNotFound", err)
+	}
+}
+
+func TestMemoryPaymentStoreSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		tr   entities.TimeRange
+		want map[string]entities.PaymentTotals
+	}{
+		{
+			name: "open range",
+			want: map[string]entities.PaymentTotals{"default": {TotalRequests: 2, AmountCents: 3050}, "fallback": {TotalRequests: 1, AmountCents: 3000}},
+		},
+		{
+			name: "bounds are inclusive",
+			tr:   entities.TimeRange{From: testTime(time.Second), To: testTime(2 * time.Second)},
+			want: map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 2050}, "fallback": {TotalRequests: 1, AmountCents: 3000}},
+		},
+		{
+			name: "open start",
+			tr:   entities.TimeRange{To: testTime(time.Second - time.Millisecond)},
+			want: map[string]entities.PaymentTotals{"default": {TotalRequests: 1, AmountCents: 1000}},
+		},
+		{
+			name: "open end",
+			tr:   entities.TimeRange{From: testTime(2 * time.Second)},
+			want: map[string]entities.PaymentTotals{"fallback": {TotalRequests: 1, AmountCents: 3000}},
+		},
+		{
+			name: "pending and parked payments only",
+			tr:   entities.TimeRange{From: testTime(3 * time.Second)},
+			want: map[string]entities.PaymentTotals{},
+		},
+	}
+
+	m := seedMemoryStore(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Summary(context.Background(), tt.tr)
+			if err != nil {
+				t.Fatalf("summary: %v", err)
+			}
+
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryPaymentStoreSearchPagination(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter entities.PaymentFilter
+		limit  int
+		want   [][]string
+	}{
+		{"every payment", entities.PaymentFilter{}, 2, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{"single page", entities.PaymentFilter{}, 10, [][]string{{"a", "b", "c", "d", "e"}}},
+		{"by processor", entities.PaymentFilter{Processor: "default"}, 1, [][]string{{"a"}, {"b"}}},
+		{"by status", entities.PaymentFilter{Status: entities.PaymentStatusPending}, 2, [][]string{{"d"}}},
+		{"by time range", entities.PaymentFilter{TimeRange: entities.TimeRange{From: testTime(time.Second), To: testTime(3 * time.Second)}}, 2, [][]string{{"b", "c"}, {"d"}}},
+		{"by amount", entities.PaymentFilter{MinAmountCents: ptr(int64(2050)), MaxAmountCents: ptr(int64(4000))}, 2, [][]string{{"b", "c"}, {"d"}}},
+	}
+
+	m := seedMemoryStore(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var after *entities.PaymentCursor
+			var pages [][]string
+
+			for range len(tt.want) + 1 {
+				records, err := m.Search(context.Background(), tt.filter, after, tt.limit)
+				if err != nil {
+					t.Fatalf("search: %v", err)
+				}
+
+				if len(records) == 0 {
+					break
+				}
+
+				pages = append(pages, correlationIds(records))
+				last := records[len(records)-1]
+				after = &entities.PaymentCursor{RequestedAt: last.RequestedAt, Id: last.Id}
+			}
+
+			if !slices.EqualFunc(pages, tt.want, slices.Equal) {
+				t.Errorf("got pages %v, want %v", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryPaymentStoreSearchSameRequestedAt(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryPaymentStore()
+
+	// ties on requested_at are broken by id, so no payment is skipped nor repeated across pages
+	for _, correlationId := range []string{"a", "b", "c"} {
+		m.CreatePending(ctx, testPayment(correlationId, 1, 0))
+	}
+
+	first, _ := m.Search(ctx, entities.PaymentFilter{}, nil, 2)
+	last := first[len(first)-1]
+	second, _ := m.Search(ctx, entities.PaymentFilter{}, &entities.PaymentCursor{RequestedAt: last.RequestedAt, Id: last.Id}, 2)
+
+	if got := correlationIds(append(first, second...)); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %v, want [a b c]", got)
+	}
+}
+
+func TestMemoryPaymentStorePurge(t *testing.T) {
+	tests := []struct {
+		name        string
+		filter      entities.PaymentFilter
+		wantDeleted int64
+		wantLeft    []string
+	}{
+		{"everything", entities.PaymentFilter{}, 5, nil},
+		{"by processor", entities.PaymentFilter{Processor: "default"}, 2, []string{"c", "d", "e"}},
+		{"by status", entities.PaymentFilter{Status: entities.PaymentStatusParked}, 1, []string{"a", "b", "c", "d"}},
+		{"by time range", entities.PaymentFilter{TimeRange: entities.TimeRange{From: testTime(time.Second), To: testTime(2 * time.Second)}}, 2, []string{"a", "d", "e"}},
+		{"by processor and time range", entities.PaymentFilter{TimeRange: entities.TimeRange{From: testTime(time.Second)}, Processor: "default"}, 1, []string{"a", "c", "d", "e"}},
+		{"nothing matching", entities.PaymentFilter{Processor: "fallback", Status: entities.PaymentStatusPending}, 0, []string{"a", "b", "c", "d", "e"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := seedMemoryStore(t)
+
+			deleted, err := m.Purge(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("purge: %v", err)
+			}
+
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted %d, want %d", deleted, tt.wantDeleted)
+			}
+
+			left, _ := m.Search(ctx, entities.PaymentFilter{}, nil, 10)
+			if got := correlationIds(left); !slices.Equal(got, tt.wantLeft) {
+				t.Errorf("left %v, want %v", got, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
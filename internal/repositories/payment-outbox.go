@@ -0,0 +1,112 @@
+package repositories
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+// PaymentOutbox records payments along with the intent of forwarding them, for the outbox dispatcher to deliver
+type PaymentOutbox interface {
+	EnqueueForwarding(ctx context.Context, p *entities.Payment) error
+}
+
+var _ PaymentOutbox = (*PaymentRepository)(nil)
+
+// EnqueueForwarding records the payment as pending and its forwarding intent in one transaction, so neither exists without the other
+func (r *PaymentRepository) EnqueueForwarding(ctx context.Context, p *entities.Payment) error {
+	return r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		_, err := rep.upsertStatus(ctx, p, nil, entities.PaymentStatusPending)
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx,
+			"INSERT INTO payment_outbox (correlation_id, amount, requested_at) VALUES ($1, $2, $3)",
+			p.CorrelationId, int(math.Round(p.Amount*100)), p.RequestedAt.Format(requestedAtLayout),
+		)
+		return err
+	})
+}
+
+// ClaimOutbox leases up to limit available intents, oldest first, skipping those leased by other dispatchers
+func (r *PaymentRepository) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]entities.OutboxIntent, error) {
+	res, err := r.conn().Query(ctx, `
+		UPDATE payment_outbox
+		SET available_at = NOW() + $2::interval, attempts = attempts + 1
+		WHERE id IN (
+			SELECT id
+			FROM payment_outbox
+			WHERE done_at IS NULL AND available_at <= NOW()
+			ORDER BY available_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, correlation_id::TEXT, amount, requested_at, attempts
+	`, limit, lease)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var intents []entities.OutboxIntent
+	for res.Next() {
+		intent := entities.OutboxIntent{P: &entities.Payment{}}
+		var amountCents int64
+
+		err = res.Scan(&intent.Id, &intent.P.CorrelationId, &amountCents, &intent.P.RequestedAt, &intent.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		intent.P.Amount = float64(amountCents) / 100
+		intents = append(intents, intent)
+	}
+
+	return intents, res.Err()
+}
+
+// CompleteOutbox marks the payment processed and its intent done in one transaction
+func (r *PaymentRepository) CompleteOutbox(ctx context.Context, intent *entities.OutboxIntent, processorUsed string) error {
+	return r.finishOutbox(ctx, intent, &processorUsed, entities.PaymentStatusProcessed)
+}
+
+// ParkOutbox gives up on the intent, leaving its payment parked
+func (r *PaymentRepository) ParkOutbox(ctx context.Context, intent *entities.OutboxIntent) error {
+	return r.finishOutbox(ctx, intent, nil, entities.PaymentStatusParked)
+}
+
+// RetryOutbox makes the intent available again after the given delay
+func (r *PaymentRepository) RetryOutbox(ctx context.Context, intent *entities.OutboxIntent, after time.Duration, reason string) error {
+	_, err := r.conn().Exec(ctx,
+		"UPDATE payment_outbox SET available_at = NOW() + $2::interval, last_error = $3 WHERE id = $1",
+		intent.Id, after, reason,
+	)
+
+	return err
+}
+
+// PruneOutbox deletes intents done before the given time and returns how many were deleted
+func (r *PaymentRepository) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.conn().Exec(ctx, "DELETE FROM payment_outbox WHERE done_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (r *PaymentRepository) finishOutbox(ctx context.Context, intent *entities.OutboxIntent, processorUsed *string, status string) error {
+	return r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		_, err := rep.upsertStatus(ctx, intent.P, processorUsed, status)
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, "UPDATE payment_outbox SET done_at = NOW() WHERE id = $1", intent.Id)
+		return err
+	})
+}
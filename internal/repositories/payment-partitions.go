@@ -0,0 +1,227 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+const (
+	partitionPrefix     = "payments_p"
+	partitionNameLayout = "20060102"
+	partitionDay        = 24 * time.Hour
+)
+
+var ErrRangeArchived = errors.New("range reaches archived payments, which can only be summarized by whole minutes")
+
+// CreatePartitions creates the daily partitions of the days starting at from and of those left in the default partition
+func (r *PaymentRepository) CreatePartitions(ctx context.Context, from time.Time, days int) error {
+	day := from.UTC().Truncate(partitionDay)
+
+	var partitionDays []time.Time
+	for range days {
+		partitionDays = append(partitionDays, day)
+		day = day.Add(partitionDay)
+	}
+
+	defaultDays, err := r.defaultPartitionDays(ctx)
+	if err != nil {
+		return fmt.Errorf("listing days in the default partition: %w", err)
+	}
+
+	var errs []error
+	for _, day := range append(defaultDays, partitionDays...) {
+		err = r.createPartition(ctx, day)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("creating partition %s: %w", partitionPrefix+day.Format(partitionNameLayout), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// defaultPartitionDays lists the days of the payments that landed in the default partition, but the days already archived
+func (r *PaymentRepository) defaultPartitionDays(ctx context.Context) ([]time.Time, error) {
+	res, err := r.conn().Query(ctx, `
+		SELECT DISTINCT date_trunc('day', d.requested_at) AS day
+		FROM payments_default d
+		WHERE NOT EXISTS (
+			SELECT 1 FROM payment_archives a
+			WHERE d.requested_at >= a.range_from AND d.requested_at < a.range_to
+		)
+		ORDER BY day
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var days []time.Time
+	for res.Next() {
+		var day time.Time
+
+		err = res.Scan(&day)
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, day)
+	}
+
+	return days, res.Err()
+}
+
+// createPartition moves the payments of the day out of the default partition, as Postgres requires, and into the new one
+func (r *PaymentRepository) createPartition(ctx context.Context, day time.Time) error {
+	name := pgx.Identifier{partitionPrefix + day.Format(partitionNameLayout)}.Sanitize()
+	dayFrom, dayTo := day.Format(time.DateOnly), day.Add(partitionDay).Format(time.DateOnly)
+
+	return r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		var exists bool
+		err := rep.conn().QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+		if err != nil || exists {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, "CREATE TEMP TABLE payments_moved (LIKE payments) ON COMMIT DROP")
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, `
+			WITH moved AS (
+				DELETE FROM payments_default
+				WHERE requested_at >= $1 AND requested_at < $2
+				RETURNING *
+			)
+			INSERT INTO payments_moved SELECT * FROM moved
+		`, dayFrom, dayTo)
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, fmt.Sprintf(
+			"CREATE TABLE %s PARTITION OF payments FOR VALUES FROM ('%s') TO ('%s')", name, dayFrom, dayTo,
+		))
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, "INSERT INTO payments SELECT * FROM payments_moved")
+		return err
+	})
+}
+
+// Partitions lists the daily partitions attached to payments, oldest first
+func (r *PaymentRepository) Partitions(ctx context.Context) ([]entities.PaymentPartition, error) {
+	res, err := r.conn().Query(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'payments'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var partitions []entities.PaymentPartition
+	var name string
+
+	for res.Next() {
+		err = res.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		day, err := time.Parse(partitionNameLayout, strings.TrimPrefix(name, partitionPrefix))
+		if !strings.HasPrefix(name, partitionPrefix) || err != nil {
+			continue
+		}
+
+		partitions = append(partitions, entities.PaymentPartition{Name: name, From: day, To: day.Add(partitionDay)})
+	}
+
+	return partitions, res.Err()
+}
+
+// DetachPartition detaches a partition past retention and records it as pending archival, keeping its rollups
+func (r *PaymentRepository) DetachPartition(ctx context.Context, partition entities.PaymentPartition) error {
+	return r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		_, err := rep.conn().Exec(ctx, fmt.Sprintf("ALTER TABLE payments DETACH PARTITION %s", pgx.Identifier{partition.Name}.Sanitize()))
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, `
+			INSERT INTO payment_archives (partition_name, range_from, range_to)
+			VALUES ($1, $2, $3)
+		`, partition.Name, partition.From, partition.To)
+		return err
+	})
+}
+
+// PendingArchives lists the partitions detached but not archived yet, oldest first
+func (r *PaymentRepository) PendingArchives(ctx context.Context) ([]entities.PaymentPartition, error) {
+	res, err := r.conn().Query(ctx, `
+		SELECT partition_name, range_from, range_to
+		FROM payment_archives
+		WHERE file IS NULL
+		ORDER BY range_from
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var partitions []entities.PaymentPartition
+	for res.Next() {
+		var partition entities.PaymentPartition
+
+		err = res.Scan(&partition.Name, &partition.From, &partition.To)
+		if err != nil {
+			return nil, err
+		}
+
+		partitions = append(partitions, partition)
+	}
+
+	return partitions, res.Err()
+}
+
+// CompleteArchive records where the payments of a detached partition were archived to, and drops it along with their correlation ids
+func (r *PaymentRepository) CompleteArchive(ctx context.Context, partition entities.PaymentPartition, file string, totalPayments int64) error {
+	return r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		_, err := rep.conn().Exec(ctx, `
+			UPDATE payment_archives
+			SET file = $2, total_payments = $3, archived_at = NOW()
+			WHERE partition_name = $1
+		`, partition.Name, file, totalPayments)
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, "DELETE FROM payment_ids WHERE requested_at >= $1 AND requested_at < $2", partition.From, partition.To)
+		if err != nil {
+			return err
+		}
+
+		_, err = rep.conn().Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", pgx.Identifier{partition.Name}.Sanitize()))
+		return err
+	})
+}
+
+// ArchivedBefore returns the time before which payments were archived, or nil when nothing was
+func (r *PaymentRepository) ArchivedBefore(ctx context.Context) (*time.Time, error) {
+	var archivedBefore *time.Time
+
+	err := r.readConn(ctx).QueryRow(ctx, "SELECT MAX(range_to) FROM payment_archives").Scan(&archivedBefore)
+
+	return archivedBefore, err
+}
@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+// Search returns up to limit payments matching the filter that come after the cursor, in (requested_at, id) order
+func (r *PaymentRepository) Search(ctx context.Context, filter entities.PaymentFilter, after *entities.PaymentCursor, limit int) ([]entities.PaymentRecord, error) {
+	conditions, args := timeRangeConditions(filter.TimeRange, "requested_at", nil)
+
+	if filter.Processor != "" {
+		args = append(args, filter.Processor)
+		conditions = append(conditions, fmt.Sprintf("processor_used = $%d", len(args)))
+	}
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	if filter.MinAmountCents != nil {
+		args = append(args, *filter.MinAmountCents)
+		conditions = append(conditions, fmt.Sprintf("amount >= $%d", len(args)))
+	}
+
+	if filter.MaxAmountCents != nil {
+		args = append(args, *filter.MaxAmountCents)
+		conditions = append(conditions, fmt.Sprintf("amount <= $%d", len(args)))
+	}
+
+	if after != nil {
+		args = append(args, after.RequestedAt, after.Id)
+		conditions = append(conditions, fmt.Sprintf("(requested_at, id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, limit)
+
+	res, err := r.readConn(ctx).Query(ctx, fmt.Sprintf(`
+		SELECT id, correlation_id::TEXT, COALESCE(amount, 0), processor_used, status, requested_at
+		FROM payments
+		WHERE %s
+		ORDER BY requested_at, id
+		LIMIT $%d
+	`, where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	records := make([]entities.PaymentRecord, 0, limit)
+	for res.Next() {
+		var record entities.PaymentRecord
+
+		err = res.Scan(&record.Id, &record.CorrelationId, &record.AmountCents, &record.Processor, &record.Status, &record.RequestedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, res.Err()
+}
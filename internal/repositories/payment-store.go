@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+var ErrPaymentNotFound = errors.New("payment not found")
+
+type onDurableKey struct{}
+
+// WithOnDurable makes a status write done with ctx call fn once it is durable, not on failure nor inside a transaction
+func WithOnDurable(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, onDurableKey{}, fn)
+}
+
+// onDurable is the fn of WithOnDurable, or a no-op
+func onDurable(ctx context.Context) func() {
+	if fn, ok := ctx.Value(onDurableKey{}).(func()); ok {
+		return fn
+	}
+
+	return func() {}
+}
+
+// PaymentStore keeps payments and their status
+type PaymentStore interface {
+	Ping(ctx context.Context) error
+
+	// CreatePending records a payment that was accepted but not yet forwarded to any processor
+	CreatePending(ctx context.Context, p *entities.Payment) error
+	// MarkProcessed records the payment as processed for good, creating it if needed
+	MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error
+	// MarkParked records a payment we gave up forwarding
+	MarkParked(ctx context.Context, p *entities.Payment) error
+
+	Get(ctx context.Context, correlationId string) (*entities.PaymentRecord, error)
+	// PendingCount counts payments requested up to the given time that are neither processed nor parked
+	PendingCount(ctx context.Context, to time.Time) (int64, error)
+	PendingPayments(ctx context.Context, tr entities.TimeRange, limit int) ([]entities.Payment, error)
+	ProcessedCorrelationIds(ctx context.Context, processor string, tr entities.TimeRange, limit int) ([]string, error)
+
+	// Summary returns the totals of processed payments per processor
+	Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error)
+	// SummaryBuckets returns the totals of Summary per interval, leaving out empty buckets
+	SummaryBuckets(ctx context.Context, tr entities.TimeRange, interval time.Duration) ([]entities.PaymentBucket, error)
+	// Search returns up to limit payments matching the filter that come after the cursor, in (requested_at, id) order
+	Search(ctx context.Context, filter entities.PaymentFilter, after *entities.PaymentCursor, limit int) ([]entities.PaymentRecord, error)
+	// Export calls fn for every payment in the range, in (requested_at, id) order, and stops at the first error it returns
+	Export(ctx context.Context, tr entities.TimeRange, fn func(*entities.PaymentRecord) error) error
+
+	// Purge deletes the payments matching the filter and returns how many were deleted, when the store can tell
+	Purge(ctx context.Context, filter entities.PaymentFilter) (int64, error)
+}
+
+var (
+	_ PaymentStore = (*PaymentRepository)(nil)
+	_ PaymentStore = (*MemoryPaymentStore)(nil)
+	_ PaymentStore = (*WriteBehindPaymentStore)(nil)
+)
@@ -0,0 +1,215 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+const rollupBucket = time.Minute
+
+// Summary reads the whole minutes of the range from payment_rollups and scans only the partial ones at its edges
+func (r *PaymentRepository) Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	var rollupConditions, tailConditions []string
+	var args []any
+
+	// rollups cover [lo, hi): from rounds up and the exclusive end rounds down to the minute
+	var lo, hi *time.Time
+
+	if tr.From != nil {
+		ceil := tr.From.Truncate(rollupBucket)
+		if ceil.Before(*tr.From) {
+			ceil = ceil.Add(rollupBucket)
+		}
+
+		lo = &ceil
+	}
+
+	if tr.To != nil {
+		floor := tr.To.Add(time.Millisecond).Truncate(rollupBucket)
+		hi = &floor
+	}
+
+	err := r.checkArchivedEdges(ctx, tr, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	// the range does not contain a single whole bucket
+	if lo != nil && hi != nil && !lo.Before(*hi) {
+		return r.summaryScan(ctx, tr)
+	}
+
+	if lo != nil {
+		args = append(args, *tr.From, *lo)
+		rollupConditions = append(rollupConditions, fmt.Sprintf("bucket >= $%d", len(args)))
+		tailConditions = append(tailConditions, fmt.Sprintf("(requested_at >= $%d AND requested_at < $%d)", len(args)-1, len(args)))
+	}
+
+	if hi != nil {
+		args = append(args, *hi, *tr.To)
+		rollupConditions = append(rollupConditions, fmt.Sprintf("bucket < $%d", len(args)-1))
+		tailConditions = append(tailConditions, fmt.Sprintf("(requested_at >= $%d AND requested_at <= $%d)", len(args)-1, len(args)))
+	}
+
+	rollupWhere := "TRUE"
+	if len(rollupConditions) > 0 {
+		rollupWhere = strings.Join(rollupConditions, " AND ")
+	}
+
+	tailWhere := "FALSE"
+	if len(tailConditions) > 0 {
+		tailWhere = strings.Join(tailConditions, " OR ")
+	}
+
+	return r.querySummary(ctx, fmt.Sprintf(`
+		SELECT
+		t.processor,
+		SUM(t.total_requests)::BIGINT AS total_requests,
+		SUM(t.total_amount)::BIGINT AS total_amount
+		FROM (
+			SELECT processor, total_requests, total_amount
+			FROM payment_rollups
+			WHERE %s
+			UNION ALL
+			SELECT processor_used, COUNT(*), COALESCE(SUM(amount), 0)
+			FROM payments
+			WHERE processor_used IS NOT NULL AND (%s)
+			GROUP BY processor_used
+		) t
+		GROUP BY t.processor;
+	`, rollupWhere, tailWhere), args...)
+}
+
+// checkArchivedEdges fails with ErrRangeArchived when a partial minute Summary would scan from payments was archived already
+func (r *PaymentRepository) checkArchivedEdges(ctx context.Context, tr entities.TimeRange, lo, hi *time.Time) error {
+	if tr.From == nil && tr.To == nil {
+		return nil
+	}
+
+	archivedBefore, err := r.ArchivedBefore(ctx)
+	if err != nil || archivedBefore == nil {
+		return err
+	}
+
+	scanOnly := lo != nil && hi != nil && !lo.Before(*hi)
+	lowerEdge := tr.From != nil && (scanOnly || lo.After(*tr.From))
+	upperEdge := tr.To != nil && !scanOnly && !hi.After(*tr.To)
+
+	if (lowerEdge && tr.From.Before(*archivedBefore)) || (upperEdge && hi.Before(*archivedBefore)) {
+		return ErrRangeArchived
+	}
+
+	return nil
+}
+
+func (r *PaymentRepository) summaryScan(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	conditions, args := timeRangeConditions(tr, "p.requested_at", nil)
+
+	return r.querySummary(ctx, fmt.Sprintf(`
+		SELECT
+		p.processor_used AS processor,
+		COUNT(*) AS total_requests,
+		COALESCE(SUM(p.amount), 0) AS total_amount
+		FROM payments p
+		WHERE %s
+		GROUP BY p.processor_used;
+	`, strings.Join(append(conditions, "p.processor_used IS NOT NULL"), " AND ")), args...)
+}
+
+func (r *PaymentRepository) querySummary(ctx context.Context, query string, args ...any) (map[string]entities.PaymentTotals, error) {
+	res, err := r.readConn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	summary := make(map[string]entities.PaymentTotals)
+
+	var processor string
+	var totals entities.PaymentTotals
+
+	for res.Next() {
+		err = res.Scan(&processor, &totals.TotalRequests, &totals.AmountCents)
+		if err != nil {
+			return nil, err
+		}
+
+		summary[processor] = totals
+	}
+
+	return summary, res.Err()
+}
+
+// SummaryBuckets splits the totals of Summary in buckets of the given interval
+func (r *PaymentRepository) SummaryBuckets(ctx context.Context, tr entities.TimeRange, interval time.Duration) ([]entities.PaymentBucket, error) {
+	archivedBefore, err := r.ArchivedBefore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// buckets are scanned from payments, so none of them can reach archived payments
+	if archivedBefore != nil && (tr.From == nil || tr.From.Before(*archivedBefore)) {
+		return nil, ErrRangeArchived
+	}
+
+	args := []any{interval}
+	conditions, args := timeRangeConditions(tr, "p.requested_at", args)
+
+	res, err := r.readConn(ctx).Query(ctx, fmt.Sprintf(`
+		SELECT
+		date_bin($1::interval, p.requested_at, TIMESTAMP '2000-01-01') AS bucket,
+		p.processor_used AS processor,
+		COUNT(*) AS total_requests,
+		COALESCE(SUM(p.amount), 0) AS total_amount
+		FROM payments p
+		WHERE %s
+		GROUP BY bucket, p.processor_used
+		ORDER BY bucket;
+	`, strings.Join(append(conditions, "p.processor_used IS NOT NULL"), " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var buckets []entities.PaymentBucket
+
+	var start time.Time
+	var processor string
+	var totals entities.PaymentTotals
+
+	for res.Next() {
+		err = res.Scan(&start, &processor, &totals.TotalRequests, &totals.AmountCents)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+			buckets = append(buckets, entities.PaymentBucket{Start: start, Processors: make(map[string]entities.PaymentTotals)})
+		}
+
+		buckets[len(buckets)-1].Processors[processor] = totals
+	}
+
+	return buckets, res.Err()
+}
+
+// timeRangeConditions appends the range bounds to args and returns the matching conditions over column
+func timeRangeConditions(tr entities.TimeRange, column string, args []any) ([]string, []any) {
+	var conditions []string
+
+	if tr.From != nil {
+		args = append(args, *tr.From)
+		conditions = append(conditions, fmt.Sprintf("%s >= $%d", column, len(args)))
+	}
+
+	if tr.To != nil {
+		args = append(args, *tr.To)
+		conditions = append(conditions, fmt.Sprintf("%s <= $%d", column, len(args)))
+	}
+
+	return conditions, args
+}
@@ -0,0 +1,296 @@
+package repositories
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+// past this many buffered writes per batch size, writers wait for a flush instead of growing the buffer while the database can't keep up
+const writeBehindMaxBacklogBatches = 10
+
+// buffered writes Postgres rejected, recorded in payment_write_rejections
+var writeBehindRejected = expvar.NewInt("write_behind_rejected")
+
+// WriteBehindPaymentStore buffers status writes and sends them to Postgres in batches, reads flush first
+type WriteBehindPaymentStore struct {
+	*PaymentRepository
+
+	size     int
+	interval time.Duration
+
+	buffer   []statusWrite
+	bufferMu sync.Mutex
+	// a single flush at a time keeps the writes in the order they were made
+	flushMu sync.Mutex
+
+	// once closed nothing flushes the buffer anymore, so writes go straight to Postgres
+	closed bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type statusWrite struct {
+	payment       entities.Payment
+	processorUsed *string
+	status        string
+	// called once the write is flushed, nil when no one waits for it
+	onDurable func()
+}
+
+func NewWriteBehindPaymentStore(rep *PaymentRepository, size int, interval time.Duration) *WriteBehindPaymentStore {
+	return &WriteBehindPaymentStore{
+		PaymentRepository: rep,
+		size:              size,
+		interval:          interval,
+		wake:              make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+}
+
+func (w *WriteBehindPaymentStore) StartWriteBehind() {
+	ticker := time.NewTicker(w.interval)
+	go func() {
+		defer close(w.done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			case <-w.wake:
+			}
+
+			err := w.Flush(context.Background())
+			if err != nil {
+				slog.Error("Error flushing buffered payment writes, retrying on next flush", "err", err)
+			}
+		}
+	}()
+}
+
+// Close stops the periodic flushes and writes everything still buffered
+func (w *WriteBehindPaymentStore) Close(ctx context.Context) error {
+	w.bufferMu.Lock()
+	w.closed = true
+	w.bufferMu.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	return w.Flush(ctx)
+}
+
+// Flush sends every buffered write in a single batch, buffering all of it again on failure
+func (w *WriteBehindPaymentStore) Flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.bufferMu.Lock()
+	writes := w.buffer
+	w.buffer = nil
+	w.bufferMu.Unlock()
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for i := range writes {
+		batch.Queue(upsertStatusQuery, upsertStatusArgs(&writes[i].payment, writes[i].processorUsed, writes[i].status)...)
+	}
+
+	err := w.db.Conn.SendBatch(ctx, batch).Close()
+	if err == nil {
+		slog.Debug("flushed buffered payment writes", "count", len(writes))
+		for i := range writes {
+			writes[i].durable()
+		}
+
+		return nil
+	}
+
+	if isUnavailableErr(err) || ctx.Err() != nil {
+		w.rebuffer(writes)
+		return err
+	}
+
+	// a rejected write rolls back the whole batch, so each is applied again in a savepoint and the rejected ones recorded
+	slog.Warn("Buffered payment writes rejected as a batch, applying them one by one", "count", len(writes), "err", err)
+
+	var rejected int64
+	err = w.PaymentRepository.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		rejected = 0
+
+		for i := range writes {
+			err := rep.WithTx(ctx, pgx.TxOptions{}, func(savepoint *PaymentRepository) error {
+				_, err := savepoint.upsertStatus(ctx, &writes[i].payment, writes[i].processorUsed, writes[i].status)
+				return err
+			})
+			if err == nil {
+				continue
+			}
+
+			if isUnavailableErr(err) || ctx.Err() != nil {
+				return err
+			}
+
+			slog.Error("Buffered payment write rejected, recording it in payment_write_rejections", "correlationId", writes[i].payment.CorrelationId, "status", writes[i].status, "err", err)
+
+			err = rep.rejectWrite(ctx, &writes[i], err)
+			if err != nil {
+				return err
+			}
+			rejected++
+		}
+
+		return nil
+	})
+	if err != nil {
+		w.rebuffer(writes)
+		return err
+	}
+
+	writeBehindRejected.Add(rejected)
+	for i := range writes {
+		writes[i].durable()
+	}
+
+	return nil
+}
+
+func (r *PaymentRepository) rejectWrite(ctx context.Context, write *statusWrite, reason error) error {
+	args := upsertStatusArgs(&write.payment, write.processorUsed, write.status)[:5]
+
+	_, err := r.conn().Exec(ctx, `
+		INSERT INTO payment_write_rejections (correlation_id, amount, processor_used, requested_at, status, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, append(args, reason.Error())...)
+	return err
+}
+
+func (s *statusWrite) durable() {
+	if s.onDurable != nil {
+		s.onDurable()
+	}
+}
+
+// rebuffer puts writes back ahead of the ones buffered since, keeping them in order
+func (w *WriteBehindPaymentStore) rebuffer(writes []statusWrite) {
+	w.bufferMu.Lock()
+	w.buffer = append(writes, w.buffer...)
+	w.bufferMu.Unlock()
+}
+
+func (w *WriteBehindPaymentStore) CreatePending(ctx context.Context, p *entities.Payment) error {
+	return w.enqueue(ctx, statusWrite{*p, nil, entities.PaymentStatusPending, onDurable(ctx)})
+}
+
+func (w *WriteBehindPaymentStore) MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error {
+	return w.enqueue(ctx, statusWrite{*p, &processorUsed, entities.PaymentStatusProcessed, onDurable(ctx)})
+}
+
+func (w *WriteBehindPaymentStore) MarkParked(ctx context.Context, p *entities.Payment) error {
+	return w.enqueue(ctx, statusWrite{*p, nil, entities.PaymentStatusParked, onDurable(ctx)})
+}
+
+func (w *WriteBehindPaymentStore) Get(ctx context.Context, correlationId string) (*entities.PaymentRecord, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.Get(ctx, correlationId)
+}
+
+func (w *WriteBehindPaymentStore) PendingCount(ctx context.Context, to time.Time) (int64, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.PendingCount(ctx, to)
+}
+
+func (w *WriteBehindPaymentStore) PendingPayments(ctx context.Context, tr entities.TimeRange, limit int) ([]entities.Payment, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.PendingPayments(ctx, tr, limit)
+}
+
+func (w *WriteBehindPaymentStore) ProcessedCorrelationIds(ctx context.Context, processor string, tr entities.TimeRange, limit int) ([]string, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.ProcessedCorrelationIds(ctx, processor, tr, limit)
+}
+
+func (w *WriteBehindPaymentStore) Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.Summary(ctx, tr)
+}
+
+func (w *WriteBehindPaymentStore) SummaryBuckets(ctx context.Context, tr entities.TimeRange, interval time.Duration) ([]entities.PaymentBucket, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.SummaryBuckets(ctx, tr, interval)
+}
+
+func (w *WriteBehindPaymentStore) Search(ctx context.Context, filter entities.PaymentFilter, after *entities.PaymentCursor, limit int) ([]entities.PaymentRecord, error) {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.Search(ctx, filter, after, limit)
+}
+
+func (w *WriteBehindPaymentStore) Export(ctx context.Context, tr entities.TimeRange, fn func(*entities.PaymentRecord) error) error {
+	w.flushBeforeRead(ctx)
+	return w.PaymentRepository.Export(ctx, tr, fn)
+}
+
+// Purge flushes first, otherwise buffered writes would bring purged payments back
+func (w *WriteBehindPaymentStore) Purge(ctx context.Context, filter entities.PaymentFilter) (int64, error) {
+	err := w.Flush(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.PaymentRepository.Purge(ctx, filter)
+}
+
+func (w *WriteBehindPaymentStore) enqueue(ctx context.Context, write statusWrite) error {
+	w.bufferMu.Lock()
+	backlog := len(w.buffer)
+	w.bufferMu.Unlock()
+
+	if backlog >= w.size*writeBehindMaxBacklogBatches {
+		err := w.Flush(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.bufferMu.Lock()
+	if w.closed {
+		w.bufferMu.Unlock()
+
+		_, err := w.PaymentRepository.upsertStatus(ctx, &write.payment, write.processorUsed, write.status)
+		return err
+	}
+
+	w.buffer = append(w.buffer, write)
+	full := len(w.buffer) >= w.size
+	w.bufferMu.Unlock()
+
+	if full {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// flushBeforeRead still lets the read go on when the flush fails, it just may miss the writes still buffered
+func (w *WriteBehindPaymentStore) flushBeforeRead(ctx context.Context) {
+	err := w.Flush(ctx)
+	if err != nil {
+		slog.Warn("Error flushing buffered payment writes before read", "err", err)
+	}
+}
@@ -2,14 +2,57 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"math"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
 )
 
+const (
+	requestedAtLayout = "2006-01-02T15:04:05.000Z"
+
+	pgInvalidTextRepresentation = "22P02"
+	pgSerializationFailure      = "40001"
+	pgDeadlockDetected          = "40P01"
+
+	txMaxAttempts  = 3
+	txRetryBackoff = 20 * time.Millisecond
+
+	// the payment_ids row of a correlation id seen before keeps its first requested_at, so a retry updates the payment it created
+	upsertStatusQuery = `
+		WITH id AS (
+			INSERT INTO payment_ids (correlation_id, requested_at)
+			VALUES ($1, $4)
+			ON CONFLICT (correlation_id) DO UPDATE SET requested_at = payment_ids.requested_at
+			RETURNING requested_at
+		)
+		INSERT INTO payments (correlation_id, amount, processor_used, requested_at, status)
+		SELECT $1, $2::BIGINT, $3::VARCHAR, id.requested_at, $5::VARCHAR FROM id
+		ON CONFLICT (correlation_id, requested_at) DO UPDATE
+		SET processor_used = EXCLUDED.processor_used, status = EXCLUDED.status
+		WHERE payments.status <> $6
+	`
+)
+
+var ErrNoTransaction = errors.New("repository is not in a transaction")
+
+type primaryReadsKey struct{}
+
+// querier is what pgxpool.Pool and pgx.Tx have in common
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type PaymentRepository struct {
 	db *database.Db
 	tx pgx.Tx
@@ -19,8 +62,16 @@ func NewPaymentRepository(db *database.Db) *PaymentRepository {
 	return &PaymentRepository{db, nil}
 }
 
-func (r *PaymentRepository) Start() (*PaymentRepository, error) {
-	tx, err := r.db.Conn.Begin(context.TODO())
+// Begin returns a repository bound to a new transaction, or to a savepoint when already in one
+func (r *PaymentRepository) Begin(ctx context.Context, opts pgx.TxOptions) (*PaymentRepository, error) {
+	var tx pgx.Tx
+	var err error
+
+	if r.tx != nil {
+		tx, err = r.tx.Begin(ctx)
+	} else {
+		tx, err = r.db.Conn.BeginTx(ctx, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -28,32 +79,335 @@ func (r *PaymentRepository) Start() (*PaymentRepository, error) {
 	return &PaymentRepository{r.db, tx}, nil
 }
 
-func (r *PaymentRepository) Finish() {
+func (r *PaymentRepository) Commit(ctx context.Context) error {
 	if r.tx == nil {
-		return
+		return ErrNoTransaction
 	}
 
-	r.tx.Commit(context.TODO())
+	err := r.tx.Commit(ctx)
 	r.tx = nil
+
+	return err
 }
 
-func (r *PaymentRepository) Cancel() {
+func (r *PaymentRepository) Rollback(ctx context.Context) error {
 	if r.tx == nil {
-		return
+		return ErrNoTransaction
 	}
 
-	r.tx.Rollback(context.TODO())
+	err := r.tx.Rollback(ctx)
 	r.tx = nil
+
+	return err
+}
+
+// WithTx runs fn in a transaction, retried on serialization failures and deadlocks unless nested, so fn must be safe to repeat
+func (r *PaymentRepository) WithTx(ctx context.Context, opts pgx.TxOptions, fn func(rep *PaymentRepository) error) error {
+	attempts := txMaxAttempts
+	if r.tx != nil {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = r.runTx(ctx, opts, fn)
+		if !isRetryableTxErr(err) || attempt == attempts {
+			return err
+		}
+
+		slog.Warn("retrying transaction", "attempt", attempt, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryBackoff):
+		}
+	}
+
+	return err
+}
+
+func (r *PaymentRepository) runTx(ctx context.Context, opts pgx.TxOptions, fn func(rep *PaymentRepository) error) error {
+	rep, err := r.Begin(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	err = fn(rep)
+	if err != nil {
+		rep.Rollback(ctx)
+		return err
+	}
+
+	return rep.Commit(ctx)
 }
 
-func (r *PaymentRepository) Create(p *entities.Payment, processorUsed string) (pgconn.CommandTag, error) {
-	query := "INSERT INTO payments (correlation_id, amount, processor_used, requested_at) VALUES($1, $2, $3, $4)"
-	args := []any{p.CorrelationId, int(math.Round(p.Amount * 100)), processorUsed, p.RequestedAt.Format("2006-01-02T15:04:05.000Z")}
+func isRetryableTxErr(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected)
+}
 
+// conn is the transaction when the repository is in one, the pool otherwise
+func (r *PaymentRepository) conn() querier {
 	if r.tx != nil {
-		return r.tx.Exec(context.TODO(), query, args...)
+		return r.tx
+	}
+
+	return r.db.Conn
+}
+
+// WithPrimaryReads makes reads done with ctx go to the primary even when replicas are fresh enough, for reads that must see every write
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// readPool is a replica, when one is fresh enough and ctx doesn't ask for the primary
+func (r *PaymentRepository) readPool(ctx context.Context) *pgxpool.Pool {
+	if primary, _ := ctx.Value(primaryReadsKey{}).(bool); primary {
+		return r.db.Conn
+	}
+
+	return r.db.Read()
+}
+
+// readConn is conn for reads that tolerate the replica staleness, a transaction reads its own writes
+func (r *PaymentRepository) readConn(ctx context.Context) querier {
+	if r.tx != nil {
+		return r.tx
+	}
+
+	return r.readPool(ctx)
+}
+
+func (r *PaymentRepository) Ping(ctx context.Context) error {
+	return r.db.Conn.Ping(ctx)
+}
+
+func (r *PaymentRepository) CreatePending(ctx context.Context, p *entities.Payment) error {
+	_, err := r.upsertStatus(ctx, p, nil, entities.PaymentStatusPending)
+	return err
+}
+
+func (r *PaymentRepository) MarkProcessed(ctx context.Context, p *entities.Payment, processorUsed string) error {
+	_, err := r.upsertStatus(ctx, p, &processorUsed, entities.PaymentStatusProcessed)
+	return err
+}
+
+func (r *PaymentRepository) MarkParked(ctx context.Context, p *entities.Payment) error {
+	_, err := r.upsertStatus(ctx, p, nil, entities.PaymentStatusParked)
+	return err
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, correlationId string) (*entities.PaymentRecord, error) {
+	var record entities.PaymentRecord
+
+	err := r.conn().QueryRow(ctx, `
+		SELECT id, correlation_id::TEXT, COALESCE(amount, 0), processor_used, status, requested_at
+		FROM payments
+		WHERE correlation_id = $1::UUID AND requested_at = (SELECT requested_at FROM payment_ids WHERE correlation_id = $1::UUID)
+	`, correlationId).Scan(&record.Id, &record.CorrelationId, &record.AmountCents, &record.Processor, &record.Status, &record.RequestedAt)
+
+	// an id that is not even a valid uuid can't belong to any payment
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == pgInvalidTextRepresentation) {
+		return nil, ErrPaymentNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (r *PaymentRepository) PendingPayments(ctx context.Context, tr entities.TimeRange, limit int) ([]entities.Payment, error) {
+	args := []any{entities.PaymentStatusPending, limit}
+	conditions, args := timeRangeConditions(tr, "requested_at", args)
+
+	res, err := r.conn().Query(ctx, fmt.Sprintf(`
+		SELECT correlation_id::TEXT, amount, requested_at
+		FROM payments
+		WHERE %s
+		ORDER BY requested_at
+		LIMIT $2
+	`, strings.Join(append(conditions, "status = $1"), " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var payments []entities.Payment
+	for res.Next() {
+		var p entities.Payment
+		var amountCents int64
+
+		err = res.Scan(&p.CorrelationId, &amountCents, &p.RequestedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Amount = float64(amountCents) / 100
+		payments = append(payments, p)
+	}
+
+	return payments, res.Err()
+}
+
+// PendingCount counts accepted payments requested up to the given time that are neither processed nor parked
+func (r *PaymentRepository) PendingCount(ctx context.Context, to time.Time) (int64, error) {
+	var count int64
+
+	err := r.conn().QueryRow(ctx, "SELECT COUNT(*) FROM payments WHERE status = $1 AND requested_at <= $2", entities.PaymentStatusPending, to).Scan(&count)
+
+	return count, err
+}
+
+// upsertStatus inserts the payment or moves it to the given status, never out of processed
+func (r *PaymentRepository) upsertStatus(ctx context.Context, p *entities.Payment, processorUsed *string, status string) (pgconn.CommandTag, error) {
+	tag, err := r.conn().Exec(ctx, upsertStatusQuery, upsertStatusArgs(p, processorUsed, status)...)
+	if err == nil && r.tx == nil {
+		onDurable(ctx)()
+	}
+
+	return tag, err
+}
+
+func upsertStatusArgs(p *entities.Payment, processorUsed *string, status string) []any {
+	return []any{p.CorrelationId, int(math.Round(p.Amount * 100)), processorUsed, p.RequestedAt.Format(requestedAtLayout), status, entities.PaymentStatusProcessed}
+}
+
+func (r *PaymentRepository) ProcessedCorrelationIds(ctx context.Context, processor string, tr entities.TimeRange, limit int) ([]string, error) {
+	args := []any{processor, limit}
+	conditions, args := timeRangeConditions(tr, "requested_at", args)
+
+	res, err := r.conn().Query(ctx, fmt.Sprintf(`
+		SELECT correlation_id::TEXT
+		FROM payments
+		WHERE %s
+		ORDER BY requested_at
+		LIMIT $2
+	`, strings.Join(append(conditions, "processor_used = $1"), " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var correlationIds []string
+	for res.Next() {
+		var correlationId string
+
+		err = res.Scan(&correlationId)
+		if err != nil {
+			return nil, err
+		}
+
+		correlationIds = append(correlationIds, correlationId)
+	}
+
+	return correlationIds, res.Err()
+}
+
+// Purge deletes the payments matching the filter along with their outbox intents and archived rollups, truncating without a count when unfiltered
+func (r *PaymentRepository) Purge(ctx context.Context, filter entities.PaymentFilter) (int64, error) {
+	var deleted int64
+
+	err := r.WithTx(ctx, pgx.TxOptions{}, func(rep *PaymentRepository) error {
+		pending, err := rep.PendingArchives(ctx)
+		if err != nil {
+			return err
+		}
+
+		if filter.IsOpen() && filter.Processor == "" {
+			tables := []string{"payments", "payment_ids", "payment_rollups", "payment_outbox"}
+			for _, partition := range pending {
+				tables = append(tables, pgx.Identifier{partition.Name}.Sanitize())
+			}
+
+			_, err = rep.conn().Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", strings.Join(tables, ", ")))
+			return err
+		}
+
+		conditions, args := timeRangeConditions(filter.TimeRange, "requested_at", nil)
+
+		if filter.Processor == "" {
+			_, err := rep.conn().Exec(ctx, fmt.Sprintf("DELETE FROM payment_outbox WHERE %s", strings.Join(conditions, " AND ")), args...)
+			if err != nil {
+				return err
+			}
+		} else {
+			args = append(args, filter.Processor)
+			conditions = append(conditions, fmt.Sprintf("processor_used = $%d", len(args)))
+		}
+
+		err = rep.conn().QueryRow(ctx, fmt.Sprintf(`
+			WITH deleted AS (
+				DELETE FROM payments WHERE %s RETURNING correlation_id
+			), ids AS (
+				DELETE FROM payment_ids WHERE correlation_id IN (SELECT correlation_id FROM deleted)
+			)
+			SELECT COUNT(*) FROM deleted
+		`, strings.Join(conditions, " AND ")), args...).Scan(&deleted)
+		if err != nil {
+			return err
+		}
+
+		// a detached partition lost the rollup trigger along with its parent, so its rollups go down by hand
+		for _, partition := range pending {
+			var detached int64
+
+			err = rep.conn().QueryRow(ctx, fmt.Sprintf(purgeDetachedQuery, pgx.Identifier{partition.Name}.Sanitize(), strings.Join(conditions, " AND ")), args...).Scan(&detached)
+			if err != nil {
+				return err
+			}
+
+			deleted += detached
+		}
+
+		return rep.purgeArchivedRollups(ctx, filter)
+	})
+
+	return deleted, err
+}
+
+const purgeDetachedQuery = `
+	WITH deleted AS (
+		DELETE FROM %s WHERE %s RETURNING correlation_id, processor_used, requested_at, amount
+	), ids AS (
+		DELETE FROM payment_ids WHERE correlation_id IN (SELECT correlation_id FROM deleted)
+	), rollups AS (
+		UPDATE payment_rollups r
+		SET total_requests = r.total_requests - d.total_requests, total_amount = r.total_amount - d.total_amount
+		FROM (
+			SELECT processor_used, date_trunc('minute', requested_at) AS bucket, COUNT(*) AS total_requests, COALESCE(SUM(amount), 0) AS total_amount
+			FROM deleted
+			WHERE processor_used IS NOT NULL
+			GROUP BY 1, 2
+		) d
+		WHERE r.processor = d.processor_used AND r.bucket = d.bucket
+	)
+	SELECT COUNT(*) FROM deleted
+`
+
+// purgeArchivedRollups drops the rollups of archived partitions in the minutes the range covers whole
+func (r *PaymentRepository) purgeArchivedRollups(ctx context.Context, filter entities.PaymentFilter) error {
+	conditions := []string{"EXISTS (SELECT 1 FROM payment_archives a WHERE a.file IS NOT NULL AND bucket >= a.range_from AND bucket < a.range_to)"}
+	var args []any
+
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("bucket >= $%d", len(args)))
+	}
+
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("bucket + INTERVAL '1 minute' <= $%d::TIMESTAMP + INTERVAL '1 millisecond'", len(args)))
+	}
 
+	if filter.Processor != "" {
+		args = append(args, filter.Processor)
+		conditions = append(conditions, fmt.Sprintf("processor = $%d", len(args)))
 	}
 
-	return r.db.Conn.Exec(context.TODO(), query, args)
+	_, err := r.conn().Exec(ctx, fmt.Sprintf("DELETE FROM payment_rollups WHERE %s", strings.Join(conditions, " AND ")), args...)
+	return err
 }
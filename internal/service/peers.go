@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/config"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+const (
+	PeerAggregatesPath      = "/internal/aggregates"
+	PeerAggregatesPurgePath = "/internal/aggregates/purge"
+
+	// peers on a unix socket are given as unix:// followed by the socket path
+	peerUnixScheme = "unix://"
+)
+
+// PeerService reads and purges the payment aggregates of the other instances, over HTTP or a unix socket
+type PeerService struct {
+	peers     []peer
+	timeout   time.Duration
+	peerToken string
+}
+
+type peer struct {
+	addr    string
+	baseURL string
+	client  *http.Client
+}
+
+type peerTotalsResponse struct {
+	TotalRequests int64 `json:"totalRequests"`
+	AmountCents   int64 `json:"amountCents"`
+}
+
+func NewPeerService(cfg *config.Config) *PeerService {
+	ps := &PeerService{timeout: cfg.AggregationPeerTimeout, peerToken: cfg.AggregationPeerToken}
+
+	for _, addr := range cfg.AggregationPeers {
+		if socket, ok := strings.CutPrefix(addr, peerUnixScheme); ok {
+			tr := &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+				MaxIdleConns:    10,
+				IdleConnTimeout: 30 * time.Second,
+			}
+
+			ps.peers = append(ps.peers, peer{addr, "http://unix", &http.Client{Transport: tr}})
+			continue
+		}
+
+		tr := &http.Transport{MaxIdleConns: 10, IdleConnTimeout: 30 * time.Second}
+		ps.peers = append(ps.peers, peer{addr, strings.TrimSuffix(addr, "/"), &http.Client{Transport: tr}})
+	}
+
+	return ps
+}
+
+// Summary sums the totals every peer holds for the range
+func (ps *PeerService) Summary(ctx context.Context, tr entities.TimeRange) (map[string]entities.PaymentTotals, error) {
+	q := url.Values{}
+	if tr.From != nil {
+		q.Set("from", tr.From.Format(timeRangeLayout))
+	}
+
+	if tr.To != nil {
+		q.Set("to", tr.To.Format(timeRangeLayout))
+	}
+
+	summaries := make([]map[string]peerTotalsResponse, len(ps.peers))
+	err := ps.each(ctx, func(ctx context.Context, i int, p peer) error {
+		res, err := ps.do(ctx, p, http.MethodGet, PeerAggregatesPath+"?"+q.Encode())
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		return json.NewDecoder(res.Body).Decode(&summaries[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := make(map[string]entities.PaymentTotals)
+	for _, peerSummary := range summaries {
+		for processor, peerTotals := range peerSummary {
+			totals := summary[processor]
+			totals.TotalRequests += peerTotals.TotalRequests
+			totals.AmountCents += peerTotals.AmountCents
+			summary[processor] = totals
+		}
+	}
+
+	return summary, nil
+}
+
+// Purge tells every peer to drop its aggregates matching the filter
+func (ps *PeerService) Purge(ctx context.Context, filter entities.PaymentFilter) error {
+	q := url.Values{}
+	if filter.From != nil {
+		q.Set("from", filter.From.Format(timeRangeLayout))
+	}
+
+	if filter.To != nil {
+		q.Set("to", filter.To.Format(timeRangeLayout))
+	}
+
+	if filter.Processor != "" {
+		q.Set("processor", filter.Processor)
+	}
+
+	return ps.each(ctx, func(ctx context.Context, _ int, p peer) error {
+		res, err := ps.do(ctx, p, http.MethodPost, PeerAggregatesPurgePath+"?"+q.Encode())
+		if err != nil {
+			return err
+		}
+
+		return res.Body.Close()
+	})
+}
+
+// each calls fn for every peer at once and returns the first error
+func (ps *PeerService) each(ctx context.Context, fn func(ctx context.Context, i int, p peer) error) error {
+	ctx, cancel := context.WithTimeout(ctx, ps.timeout)
+	defer cancel()
+
+	errs := make([]error, len(ps.peers))
+
+	var wg sync.WaitGroup
+	for i, p := range ps.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := fn(ctx, i, p)
+			if err != nil {
+				errs[i] = fmt.Errorf("peer %s: %w", p.addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ps *PeerService) do(ctx context.Context, p peer, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("X-Peer-Token", ps.peerToken)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the only unprocessable request to a peer is a range reaching aggregates it dropped
+	if res.StatusCode == http.StatusUnprocessableEntity {
+		res.Body.Close()
+		return nil, repositories.ErrAggregatesPruned
+	}
+
+	if res.StatusCode > 399 {
+		res.Body.Close()
+		return nil, fmt.Errorf("returned status %d", res.StatusCode)
+	}
+
+	return res, nil
+}
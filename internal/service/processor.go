@@ -23,6 +23,12 @@ type ProcessorService struct {
 	mu                sync.RWMutex
 }
 
+type ProcessorResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
 const (
 	ProcessorDefault  = "default"
 	ProcessorFallback = "fallback"
@@ -31,6 +37,8 @@ const (
 	processorFallbackTimeout = 500 * time.Millisecond
 )
 
+var Processors = []string{ProcessorDefault, ProcessorFallback}
+
 func NewProcessorService(cfg *config.Config) *ProcessorService {
 	tr := &http.Transport{
 		MaxIdleConns:       10,
@@ -65,6 +73,13 @@ func (p *ProcessorService) SetFallbackTimeout(t int) {
 	p.fallbackTimeout = time.Duration(t) * time.Millisecond
 }
 
+func (p *ProcessorService) DefaultTimeout() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.defaultTimeout
+}
+
 func (p *ProcessorService) MakeRequestDefault(method, path string, body io.Reader, timeout int) (io.ReadCloser, int, error) {
 	return p.MakeRequest(ProcessorDefault, method, path, body, timeout)
 }
@@ -74,22 +89,30 @@ func (p *ProcessorService) MakeRequestFallback(method, path string, body io.Read
 }
 
 func (p *ProcessorService) MakeRequest(processor, method, path string, body io.Reader, overrideTimeout int) (io.ReadCloser, int, error) {
-	var reqPath string
-	var timeout time.Duration
+	timeout, err := p.requestTimeout(processor, overrideTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	switch processor {
-	case ProcessorDefault:
-		reqPath = fmt.Sprintf("%s%s", p.defaultURL, path)
-		timeout = p.defaultTimeout
-	case ProcessorFallback:
-		reqPath = fmt.Sprintf("%s%s", p.fallbackURL, path)
-		timeout = p.fallbackTimeout
-	default:
-		return nil, 0, errors.New("invalid processor")
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := p.do(ctx, processor, method, path, body)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if overrideTimeout > 0 {
-		timeout = time.Duration(overrideTimeout) * time.Millisecond
+	return res.Body, res.StatusCode, nil
+}
+
+// MakeBufferedRequest reads the whole response before the request context is released, for callers that need its body or headers
+func (p *ProcessorService) MakeBufferedRequest(processor, method, path string, body io.Reader, overrideTimeout int) (*ProcessorResponse, error) {
+	timeout, err := p.requestTimeout(processor, overrideTimeout)
+	if err != nil {
+		return nil, err
 	}
 
 	p.mu.RLock()
@@ -98,6 +121,54 @@ func (p *ProcessorService) MakeRequest(processor, method, path string, body io.R
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	res, err := p.do(ctx, processor, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessorResponse{
+		Status: res.StatusCode,
+		Header: res.Header,
+		Body:   resBody,
+	}, nil
+}
+
+func (p *ProcessorService) requestTimeout(processor string, overrideTimeout int) (time.Duration, error) {
+	if overrideTimeout > 0 {
+		return time.Duration(overrideTimeout) * time.Millisecond, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch processor {
+	case ProcessorDefault:
+		return p.defaultTimeout, nil
+	case ProcessorFallback:
+		return p.fallbackTimeout, nil
+	default:
+		return 0, errors.New("invalid processor")
+	}
+}
+
+func (p *ProcessorService) do(ctx context.Context, processor, method, path string, body io.Reader) (*http.Response, error) {
+	var reqPath string
+
+	switch processor {
+	case ProcessorDefault:
+		reqPath = fmt.Sprintf("%s%s", p.defaultURL, path)
+	case ProcessorFallback:
+		reqPath = fmt.Sprintf("%s%s", p.fallbackURL, path)
+	default:
+		return nil, errors.New("invalid processor")
+	}
+
 	req, err := http.NewRequestWithContext(
 		ctx,
 		method,
@@ -105,16 +176,11 @@ func (p *ProcessorService) MakeRequest(processor, method, path string, body io.R
 		body,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("X-Rinha-Token", p.processorAPIToken)
 
-	res, err := p.client.Do(req)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return res.Body, res.StatusCode, nil
+	return p.client.Do(req)
 }
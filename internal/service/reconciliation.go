@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+const (
+	reconcileRequestTimeout = 2000
+	// past this many local payments in a range we don't look them up one by one at the processor
+	reconcileMaxLookups = 100
+
+	timeRangeLayout = "2006-01-02T15:04:05.000Z"
+)
+
+type ReconciliationService struct {
+	procService  *ProcessorService
+	paymentStore repositories.PaymentStore
+}
+
+type ProcessorReconciliation struct {
+	Processor        string
+	Local            entities.PaymentTotals
+	Remote           *entities.PaymentTotals
+	Error            error
+	SuspectedMissing []string
+}
+
+type ReconciliationReport struct {
+	Range      entities.TimeRange
+	At         time.Time
+	Processors []ProcessorReconciliation
+}
+
+type processorSummaryResponse struct {
+	TotalRequests int64   `json:"totalRequests"`
+	TotalAmount   float64 `json:"totalAmount"`
+}
+
+func NewReconciliationService(procService *ProcessorService, paymentStore repositories.PaymentStore) *ReconciliationService {
+	return &ReconciliationService{procService, paymentStore}
+}
+
+func (r *ProcessorReconciliation) RequestsDelta() int64 {
+	if r.Remote == nil {
+		return 0
+	}
+
+	return r.Local.TotalRequests - r.Remote.TotalRequests
+}
+
+func (r *ProcessorReconciliation) AmountDeltaCents() int64 {
+	if r.Remote == nil {
+		return 0
+	}
+
+	return r.Local.AmountCents - r.Remote.AmountCents
+}
+
+// Reconcile compares our processed payments with each processor's, reporting an unreachable processor with its error
+func (r *ReconciliationService) Reconcile(ctx context.Context, tr entities.TimeRange) (*ReconciliationReport, error) {
+	// a stale replica would show up as drift
+	ctx = repositories.WithPrimaryReads(ctx)
+
+	local, err := r.paymentStore.Summary(ctx, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconciliationReport{Range: tr, At: time.Now().UTC()}
+
+	for _, processor := range Processors {
+		rec := ProcessorReconciliation{Processor: processor, Local: local[processor]}
+
+		rec.Remote, rec.Error = r.fetchProcessorSummary(processor, tr)
+		if rec.Remote != nil && rec.RequestsDelta() > 0 && rec.Local.TotalRequests <= reconcileMaxLookups {
+			rec.SuspectedMissing, rec.Error = r.findMissing(ctx, processor, tr)
+		}
+
+		report.Processors = append(report.Processors, rec)
+	}
+
+	return report, nil
+}
+
+func (r *ReconciliationService) fetchProcessorSummary(processor string, tr entities.TimeRange) (*entities.PaymentTotals, error) {
+	q := url.Values{}
+	if tr.From != nil {
+		q.Set("from", tr.From.Format(timeRangeLayout))
+	}
+
+	if tr.To != nil {
+		q.Set("to", tr.To.Format(timeRangeLayout))
+	}
+
+	res, err := r.procService.MakeBufferedRequest(processor, http.MethodGet, "/admin/payments-summary?"+q.Encode(), nil, reconcileRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Status > 399 {
+		return nil, fmt.Errorf("processor summary returned status %d", res.Status)
+	}
+
+	var summary processorSummaryResponse
+	err = json.Unmarshal(res.Body, &summary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.PaymentTotals{
+		TotalRequests: summary.TotalRequests,
+		AmountCents:   int64(math.Round(summary.TotalAmount * 100)),
+	}, nil
+}
+
+// findMissing looks up every payment we recorded as processed by the processor and returns the ones it does not know about
+func (r *ReconciliationService) findMissing(ctx context.Context, processor string, tr entities.TimeRange) ([]string, error) {
+	correlationIds, err := r.paymentStore.ProcessedCorrelationIds(ctx, processor, tr, reconcileMaxLookups)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, correlationId := range correlationIds {
+		res, err := r.procService.MakeBufferedRequest(processor, http.MethodGet, "/payments/"+url.PathEscape(correlationId), nil, reconcileRequestTimeout)
+		if err != nil {
+			return missing, err
+		}
+
+		if res.Status == http.StatusNotFound {
+			missing = append(missing, correlationId)
+		}
+	}
+
+	return missing, nil
+}
+
+// Repair marks the pending payments the processors confirm as processed and returns how many per processor
+func (r *ReconciliationService) Repair(ctx context.Context, tr entities.TimeRange) (map[string]int64, error) {
+	pending, err := r.paymentStore.PendingPayments(ctx, tr, reconcileMaxLookups)
+	if err != nil {
+		return nil, err
+	}
+
+	repaired := make(map[string]int64, len(Processors))
+
+	for _, p := range pending {
+		for _, processor := range Processors {
+			res, err := r.procService.MakeBufferedRequest(processor, http.MethodGet, "/payments/"+url.PathEscape(p.CorrelationId), nil, reconcileRequestTimeout)
+			if err != nil || res.Status != http.StatusOK {
+				continue
+			}
+
+			err = r.paymentStore.MarkProcessed(ctx, &p, processor)
+			if err != nil {
+				return repaired, err
+			}
+
+			repaired[processor]++
+			break
+		}
+	}
+
+	return repaired, nil
+}
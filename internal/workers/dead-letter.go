@@ -2,11 +2,18 @@ package workers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
 	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
 )
@@ -16,136 +23,342 @@ const (
 	maxDefaultProcRetries = 5
 	maxTolerableRespTime  = 1500 * time.Millisecond
 	maxAgeInQueue         = 1500 * time.Millisecond
+	maxRetriesBeforePark  = 50
+	// in milliseconds, as taken by the processor service
+	dlqLookupTimeout = 2000
 
-	FailureProcError   = "processor_error"
-	FailureProcTimeout = "processor_timeout"
+	FailureProcError     = "processor_error"
+	FailureProcTimeout   = "processor_timeout"
+	FailureProcUnhealthy = "processor_unhealthy"
+
+	PurgeChannel = "payments_purged"
 )
 
 type DLQ struct {
 	healthChecker *HealthChecker
+	scorer        *HealthScorer
 	procService   *service.ProcessorService
-	paymentRep    *repositories.PaymentRepository
+	paymentStore  repositories.PaymentStore
+	// nil when the WAL is disabled
+	wal *wal.WAL
 
 	queue chan *entities.PaymentRetry
+	stop  chan struct{}
+	done  chan struct{}
+	// signaled when a processor recovers, holding a single pending signal however many recoveries happened
+	recovered chan struct{}
+
+	// payments in a purge made since they were queued are dropped, a purge is kept while a retry queued before it is
+	purgeGeneration uint64
+	purges          []dlqPurge
+	queued          map[uint64]int
+	purgesMu        sync.Mutex
+}
+
+type dlqPurge struct {
+	generation uint64
+	tr         entities.TimeRange
 }
 
-func NewDQL(healthChecker *HealthChecker, procService *service.ProcessorService, paymentRep *repositories.PaymentRepository) *DLQ {
+func NewDQL(healthChecker *HealthChecker, scorer *HealthScorer, procService *service.ProcessorService, paymentStore repositories.PaymentStore, acceptedLog *wal.WAL) *DLQ {
 	return &DLQ{
 		healthChecker: healthChecker,
+		scorer:        scorer,
 		procService:   procService,
-		paymentRep:    paymentRep,
+		paymentStore:  paymentStore,
+		wal:           acceptedLog,
 		queue:         make(chan *entities.PaymentRetry),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		recovered:     make(chan struct{}, 1),
+		// generation 0 tells retries never queued apart
+		purgeGeneration: 1,
+		queued:          make(map[uint64]int),
 	}
 }
 
+// PushToQueue queues a payment that was never queued before, counting it in the current purge generation
 func (dlq *DLQ) PushToQueue(pr *entities.PaymentRetry) {
+	dlq.purgesMu.Lock()
+	pr.PurgeGeneration = dlq.purgeGeneration
+	dlq.queued[pr.PurgeGeneration]++
+	dlq.purgesMu.Unlock()
+
+	dlq.requeue(pr)
+}
+
+// requeue queues a retry back, it stays counted in the generation it was first queued in
+func (dlq *DLQ) requeue(pr *entities.PaymentRetry) {
 	go func() {
 		dlq.queue <- pr
 	}()
 }
 
 func (dlq *DLQ) StartDQLWorker() {
+	healthEvents, _ := dlq.healthChecker.Subscribe()
+
+	// events are read as they come, so they don't pile up in the subscription while a retry is in progress
 	go func() {
-		for paymentRetry := range dlq.queue {
+		for event := range healthEvents {
+			if event.Type != HealthEventRecovered {
+				continue
+			}
+
+			select {
+			case dlq.recovered <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	go func() {
+		defer close(dlq.done)
+
+		for {
+			var paymentRetry *entities.PaymentRetry
+			select {
+			case <-dlq.stop:
+				return
+			case paymentRetry = <-dlq.queue:
+			}
+
+			if dlq.isPurged(paymentRetry) {
+				dlq.settle(paymentRetry)
+				continue
+			}
+
 			ignoreSleep := dlq.retry(paymentRetry)
 
 			if !ignoreSleep {
-				time.Sleep(HealthCheckInterval)
+				dlq.waitForRecovery()
 			}
 		}
 	}()
 }
 
-// TODO: Before calling the processor, check if fail reason is timeout, if it is, call processor to check if payment was delivered after the timeout (read the processor code)
-func (dlq *DLQ) retry(pr *entities.PaymentRetry) bool {
+// Stop waits for the retry in progress and stops retrying, queued payments stay pending
+func (dlq *DLQ) Stop() {
+	close(dlq.stop)
+	<-dlq.done
+}
 
-	defaultHealth, fallbackHealth := dlq.healthChecker.GetProcessorsHealth()
-	if defaultHealth == nil || fallbackHealth == nil {
-		return false
+// Purge drops every queued payment requested inside the range, an open end is closed at the time of the purge
+func (dlq *DLQ) Purge(tr entities.TimeRange) {
+	if tr.To == nil {
+		now := time.Now().UTC()
+		tr.To = &now
 	}
 
-	ignoreSleep := true
+	dlq.purgesMu.Lock()
+	defer dlq.purgesMu.Unlock()
 
-	// default is healthy. Retry with default
-	if !defaultHealth.Failing /* && defaultHealth.MinResponseTime <= int64(maxTolerableRespTime) */ {
-		rep, err := dlq.paymentRep.Start()
-		if err != nil {
-			dlq.PushToQueue(pr)
-			return true
+	// retries queued from now on are out of reach of this purge
+	dlq.purges = append(dlq.purges, dlqPurge{dlq.purgeGeneration, tr})
+	dlq.purgeGeneration++
+	dlq.prunePurges()
+
+	slog.Info("DLQ purged", "from", tr.From, "to", tr.To)
+}
+
+// HandlePurgeNotification applies a purge announced on PurgeChannel by any instance
+func (dlq *DLQ) HandlePurgeNotification(payload string) {
+	var tr entities.TimeRange
+
+	err := json.Unmarshal([]byte(payload), &tr)
+	if err != nil {
+		slog.Error("Error decoding purge notification", "payload", payload, "err", err)
+		return
+	}
+
+	dlq.Purge(tr)
+}
+
+func (dlq *DLQ) isPurged(pr *entities.PaymentRetry) bool {
+	dlq.purgesMu.Lock()
+	defer dlq.purgesMu.Unlock()
+
+	for _, p := range dlq.purges {
+		if pr.PurgeGeneration > p.generation {
+			continue
 		}
 
-		_, err = rep.Create(pr.P, service.ProcessorDefault)
-		if err != nil {
-			dlq.PushToQueue(pr)
+		if (p.tr.From == nil || !pr.P.RequestedAt.Before(*p.tr.From)) && !pr.P.RequestedAt.After(*p.tr.To) {
 			return true
 		}
+	}
+
+	return false
+}
+
+// release stops counting a retry that left the queue for good
+func (dlq *DLQ) release(pr *entities.PaymentRetry) {
+	dlq.purgesMu.Lock()
+	defer dlq.purgesMu.Unlock()
+
+	dlq.queued[pr.PurgeGeneration]--
+	if dlq.queued[pr.PurgeGeneration] <= 0 {
+		delete(dlq.queued, pr.PurgeGeneration)
+		dlq.prunePurges()
+	}
+}
+
+// prunePurges drops the purges no queued retry is old enough for
+func (dlq *DLQ) prunePurges() {
+	oldest := dlq.purgeGeneration
+	for generation := range dlq.queued {
+		oldest = min(oldest, generation)
+	}
+
+	dlq.purges = slices.DeleteFunc(dlq.purges, func(p dlqPurge) bool {
+		return p.generation < oldest
+	})
+}
+
+// waitForRecovery backs off for a health check interval, waking up early on a recovery signaled after the wait started
+func (dlq *DLQ) waitForRecovery() {
+	select {
+	case <-dlq.recovered:
+	default:
+	}
+
+	timer := time.NewTimer(HealthCheckInterval)
+	defer timer.Stop()
 
+	select {
+	case <-timer.C:
+	case <-dlq.stop:
+	case <-dlq.recovered:
+	}
+}
+
+// retry forwards a queued payment once, and returns false when the worker should back off before the next one
+func (dlq *DLQ) retry(pr *entities.PaymentRetry) bool {
+	ignoreSleep := true
+
+	if pr.FailureCount >= maxRetriesBeforePark {
+		return dlq.park(pr)
+	}
+
+	// default is healthy. Retry with default
+	if dlq.scorer.IsHealthy(service.ProcessorDefault) {
 		paymentJSONBytes, _ := json.Marshal(pr.P)
+		start := time.Now()
 		_, resStatus, err := dlq.procService.MakeRequestDefault(http.MethodPost, "/payments", bytes.NewReader(paymentJSONBytes), 7000)
+		dlq.scorer.RecordForward(service.ProcessorDefault, time.Since(start), resStatus, err)
 		// request failed. Push back to queue
-		if err != nil || resStatus > 399 {
+		if !dlq.delivered(service.ProcessorDefault, pr, resStatus, err) {
 			pr.FailureCount++
 			pr.LastProcessorUsed = service.ProcessorDefault
-			pr.LastFailureReason = FailureProcError
-
-			if resStatus >= 399 {
-				pr.LastFailureReason = FailureProcTimeout
-			}
+			pr.LastFailureReason = FailureReason(err)
 
-			dlq.PushToQueue(pr)
-			ignoreSleep = false
-			rep.Cancel()
+			dlq.requeue(pr)
+			return false
 		}
 
-		rep.Finish()
+		dlq.markProcessed(pr, service.ProcessorDefault)
 		return ignoreSleep
 	}
 
 	// default is unhealthy and payment is not old enough to be sent to fallback. Push back to queue
-	if pr.P.RequestedAt.UnixMilli()-time.Now().UnixMilli() < maxAgeInQueue.Milliseconds() {
-		dlq.PushToQueue(pr)
+	if time.Since(pr.P.RequestedAt) < maxAgeInQueue {
+		dlq.requeue(pr)
 		ignoreSleep = false
 
 		return ignoreSleep
 	}
 
 	// default is unhealthy and payment is not already old enough to be sent to fallback and fallback is healthy. Retry with fallback
-	if !fallbackHealth.Failing /*&& fallbackHealth.MinResponseTime <= int64(maxTolerableRespTime)*/ {
-		rep, err := dlq.paymentRep.Start()
-		if err != nil {
-			dlq.PushToQueue(pr)
-			return true
-		}
-
-		_, err = rep.Create(pr.P, service.ProcessorFallback)
-		if err != nil {
-			dlq.PushToQueue(pr)
-			return true
-		}
-
+	if dlq.scorer.IsHealthy(service.ProcessorFallback) {
 		paymentJSONBytes, _ := json.Marshal(pr.P)
+		start := time.Now()
 		_, resStatus, err := dlq.procService.MakeRequestFallback(http.MethodPost, "/payments", bytes.NewReader(paymentJSONBytes), 7000)
-		if err != nil {
+		dlq.scorer.RecordForward(service.ProcessorFallback, time.Since(start), resStatus, err)
+		if !dlq.delivered(service.ProcessorFallback, pr, resStatus, err) {
 			pr.FailureCount++
 			pr.LastProcessorUsed = service.ProcessorFallback
-			pr.LastFailureReason = FailureProcError
-
-			if resStatus >= 399 {
-				pr.LastFailureReason = FailureProcTimeout
-			}
+			pr.LastFailureReason = FailureReason(err)
 
-			dlq.PushToQueue(pr)
-			ignoreSleep = false
-			rep.Cancel()
+			dlq.requeue(pr)
+			return false
 		}
 
-		rep.Finish()
+		dlq.markProcessed(pr, service.ProcessorFallback)
 		return ignoreSleep
 	}
 
 	// both fallback and default are unhealthy. Pushing back to queue
-	dlq.PushToQueue(pr)
+	dlq.requeue(pr)
 	ignoreSleep = false
 
 	return ignoreSleep
 }
+
+// FailureReason tells a request that timed out apart from one the processor failed or answered with an error
+func FailureReason(err error) string {
+	if isTimeoutErr(err) {
+		return FailureProcTimeout
+	}
+
+	return FailureProcError
+}
+
+// delivered tells whether the processor has the payment, a 422 only once the processor confirms it
+func (dlq *DLQ) delivered(processor string, pr *entities.PaymentRetry, resStatus int, err error) bool {
+	if err != nil {
+		return false
+	}
+
+	if resStatus != http.StatusUnprocessableEntity {
+		return resStatus < 400
+	}
+
+	res, err := dlq.procService.MakeBufferedRequest(processor, http.MethodGet, "/payments/"+url.PathEscape(pr.P.CorrelationId), nil, dlqLookupTimeout)
+	if err != nil {
+		slog.Warn("Error confirming payment rejected by the processor, retrying it", "correlationId", pr.P.CorrelationId, "processor", processor, "err", err)
+		return false
+	}
+
+	return res.Status == http.StatusOK
+}
+
+// markProcessed records a payment the processor accepted, left pending and in the WAL on failure rather than charged twice
+func (dlq *DLQ) markProcessed(pr *entities.PaymentRetry, processor string) {
+	dlq.release(pr)
+
+	err := dlq.paymentStore.MarkProcessed(repositories.WithOnDurable(context.TODO(), func() { dlq.ack(pr) }), pr.P, processor)
+	if err != nil {
+		slog.Error("Error recording processed payment", "correlationId", pr.P.CorrelationId, "processor", processor, "err", err)
+	}
+}
+
+// park gives up on a payment that kept failing, leaving it recorded as parked instead of pending
+func (dlq *DLQ) park(pr *entities.PaymentRetry) bool {
+	err := dlq.paymentStore.MarkParked(context.TODO(), pr.P)
+	if err != nil {
+		slog.Error("Error parking payment, sending back to DLQ", "correlationId", pr.P.CorrelationId, "err", err)
+		dlq.requeue(pr)
+		return false
+	}
+
+	slog.Warn("payment parked", "correlationId", pr.P.CorrelationId, "failureCount", pr.FailureCount, "lastFailureReason", pr.LastFailureReason)
+	dlq.settle(pr)
+	return true
+}
+
+// settle drops a payment from the DLQ for good, once processed, parked or purged
+func (dlq *DLQ) settle(pr *entities.PaymentRetry) {
+	dlq.release(pr)
+	dlq.ack(pr)
+}
+
+// ack releases the WAL entry of a settled payment
+func (dlq *DLQ) ack(pr *entities.PaymentRetry) {
+	if dlq.wal == nil || pr.WalSeq == 0 {
+		return
+	}
+
+	err := dlq.wal.Ack(pr.WalSeq)
+	if err != nil && !errors.Is(err, wal.ErrClosed) {
+		slog.Error("Error acknowledging wal entry", "correlationId", pr.P.CorrelationId, "seq", pr.WalSeq, "err", err)
+	}
+}
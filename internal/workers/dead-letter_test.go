@@ -0,0 +1,99 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+)
+
+var dlqTestStart = time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+
+func dlqTestTime(offset time.Duration) *time.Time {
+	t := dlqTestStart.Add(offset)
+	return &t
+}
+
+// pushTest queues a payment and takes it right back off the queue, as the worker would
+func pushTest(dlq *DLQ, offset time.Duration) *entities.PaymentRetry {
+	pr := &entities.PaymentRetry{P: &entities.Payment{CorrelationId: "a", RequestedAt: *dlqTestTime(offset)}}
+	dlq.PushToQueue(pr)
+
+	return <-dlq.queue
+}
+
+func TestDLQPurgeGenerations(t *testing.T) {
+	tests := []struct {
+		name string
+		// queued before the purge, otherwise after it
+		queuedBefore bool
+		requestedAt  time.Duration
+		purge        entities.TimeRange
+		wantPurged   bool
+	}{
+		{"queued before, inside the range", true, time.Minute, entities.TimeRange{From: dlqTestTime(0), To: dlqTestTime(time.Minute)}, true},
+		{"queued before, outside the range", true, 2 * time.Minute, entities.TimeRange{From: dlqTestTime(0), To: dlqTestTime(time.Minute)}, false},
+		{"queued before, open start", true, -time.Hour, entities.TimeRange{To: dlqTestTime(time.Minute)}, true},
+		{"queued before, open end closed at the purge", true, 2 * time.Hour, entities.TimeRange{From: dlqTestTime(0)}, false},
+		{"queued after, inside the range", false, time.Minute, entities.TimeRange{From: dlqTestTime(0), To: dlqTestTime(time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dlq := NewDQL(nil, nil, nil, nil, nil)
+
+			var pr *entities.PaymentRetry
+			if tt.queuedBefore {
+				pr = pushTest(dlq, tt.requestedAt)
+			}
+
+			dlq.Purge(tt.purge)
+
+			if !tt.queuedBefore {
+				pr = pushTest(dlq, tt.requestedAt)
+			}
+
+			if got := dlq.isPurged(pr); got != tt.wantPurged {
+				t.Errorf("purged = %v, want %v", got, tt.wantPurged)
+			}
+
+			// a retry sent back to the queue keeps the generation it was first queued in
+			dlq.requeue(pr)
+			if got := dlq.isPurged(<-dlq.queue); got != tt.wantPurged {
+				t.Errorf("purged = %v after a requeue, want %v", got, tt.wantPurged)
+			}
+		})
+	}
+}
+
+func TestDLQPurgeDroppedOnceNoRetryQueuedBefore(t *testing.T) {
+	dlq := NewDQL(nil, nil, nil, nil, nil)
+	tr := entities.TimeRange{From: dlqTestTime(0), To: dlqTestTime(time.Minute)}
+
+	first := pushTest(dlq, time.Second)
+	dlq.Purge(tr)
+	second := pushTest(dlq, 2*time.Second)
+	dlq.Purge(tr)
+
+	tests := []struct {
+		name       string
+		release    *entities.PaymentRetry
+		wantPurges int
+	}{
+		{"retries queued before both purges", nil, 2},
+		{"first retry released", first, 1},
+		{"every retry released", second, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.release != nil {
+				dlq.release(tt.release)
+			}
+
+			if got := len(dlq.purges); got != tt.wantPurges {
+				t.Errorf("%d purges kept, want %d", got, tt.wantPurges)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package workers
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	HealthEventRecovered          = "recovered"
+	HealthEventFailing            = "failing"
+	HealthEventLatencyBandChanged = "latency_band_changed"
+
+	healthEventsBufferSize = 16
+)
+
+// upper bounds in milliseconds of the latency bands, the last one also takes anything above
+var latencyBands = []int64{100, 500, 1500}
+
+type HealthEvent struct {
+	Processor string
+	Type      string
+	Previous  Health
+	Current   Health
+	At        time.Time
+}
+
+// Subscribe returns a channel of health transitions and a function to unsubscribe, slow subscribers miss events
+func (h *HealthChecker) Subscribe() (<-chan HealthEvent, func()) {
+	ch := make(chan HealthEvent, healthEventsBufferSize)
+
+	h.subsMu.Lock()
+	h.subs[ch] = struct{}{}
+	h.subsMu.Unlock()
+
+	unsubscribe := func() {
+		h.subsMu.Lock()
+		defer h.subsMu.Unlock()
+
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+func (h *HealthChecker) publishTransitions(processor string, prev, cur Health) {
+	events := healthTransitions(processor, prev, cur)
+	if len(events) == 0 {
+		return
+	}
+
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	for _, event := range events {
+		slog.Info("processor health changed", "processor", processor, "event", event.Type, "health", &event.Current)
+
+		for ch := range h.subs {
+			select {
+			case ch <- event:
+			default:
+				slog.Warn("dropping health event for slow subscriber", "processor", processor, "event", event.Type)
+			}
+		}
+	}
+}
+
+func healthTransitions(processor string, prev, cur Health) []HealthEvent {
+	var events []HealthEvent
+	now := time.Now()
+
+	if prev.IsAvailable() != cur.IsAvailable() {
+		eventType := HealthEventFailing
+		if cur.IsAvailable() {
+			eventType = HealthEventRecovered
+		}
+
+		events = append(events, HealthEvent{processor, eventType, prev, cur, now})
+	}
+
+	if !cur.Unknown && latencyBand(prev.MinResponseTime) != latencyBand(cur.MinResponseTime) {
+		events = append(events, HealthEvent{processor, HealthEventLatencyBandChanged, prev, cur, now})
+	}
+
+	return events
+}
+
+func latencyBand(minResponseTime int64) int {
+	for i, upper := range latencyBands {
+		if minResponseTime < upper {
+			return i
+		}
+	}
+
+	return len(latencyBands)
+}
@@ -0,0 +1,119 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	HealthHistoryRetention     = 24 * time.Hour
+	healthHistoryPruneInterval = 1 * time.Minute
+)
+
+var errHealthCheckRateLimited = errors.New("health check rate limited")
+
+type HealthProbe struct {
+	Processor       string
+	Failing         *bool
+	MinResponseTime *int64
+	Error           *string
+	ProbedAt        time.Time
+}
+
+func (h *HealthChecker) GetHealthHistory(ctx context.Context, processor string, since time.Time) ([]HealthProbe, error) {
+	if h.db == nil {
+		return nil, nil
+	}
+
+	res, err := h.db.Conn.Query(ctx, `
+		SELECT processor, is_falling, min_response_time, probe_error, probed_at
+		FROM processor_health_history
+		WHERE processor = $1 AND probed_at >= $2
+		ORDER BY probed_at
+	`, processor, since)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var probes []HealthProbe
+	for res.Next() {
+		var probe HealthProbe
+
+		err = res.Scan(&probe.Processor, &probe.Failing, &probe.MinResponseTime, &probe.Error, &probe.ProbedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		probes = append(probes, probe)
+	}
+
+	return probes, res.Err()
+}
+
+// CurrentHealth returns the processor health as this node sees it, even if stale
+func (h *HealthChecker) CurrentHealth(processor string) (Health, bool) {
+	ph, ok := h.processors[processor]
+	if !ok {
+		return Health{}, false
+	}
+
+	return ph.get(), true
+}
+
+func (h *HealthChecker) recordProbe(processor string, probeRes *Health, probeErr error) {
+	if h.db == nil {
+		return
+	}
+
+	var failing *bool
+	var minResponseTime *int64
+	var errMsg *string
+
+	if probeErr != nil {
+		msg := probeErr.Error()
+		errMsg = &msg
+	} else {
+		failing = &probeRes.Failing
+		minResponseTime = &probeRes.MinResponseTime
+	}
+
+	_, err := h.db.Conn.Exec(context.TODO(), `
+		INSERT INTO processor_health_history (processor, is_falling, min_response_time, probe_error)
+		VALUES ($1, $2, $3, $4)
+	`, processor, failing, minResponseTime, errMsg)
+	if err != nil {
+		slog.Error("Error recording health history:", "err", err)
+		return
+	}
+
+	lastPrunedAt := h.historyPrunedAt.Load()
+	if time.Since(time.UnixMilli(lastPrunedAt)) < healthHistoryPruneInterval || !h.historyPrunedAt.CompareAndSwap(lastPrunedAt, time.Now().UnixMilli()) {
+		return
+	}
+
+	_, err = h.db.Conn.Exec(context.TODO(), "DELETE FROM processor_health_history WHERE probed_at < $1", time.Now().Add(-HealthHistoryRetention))
+	if err != nil {
+		slog.Error("Error pruning health history:", "err", err)
+	}
+}
+
+// ResetStore forgets every stored health state and probe, as after a fresh start
+func (h *HealthChecker) ResetStore(ctx context.Context) error {
+	if h.db == nil {
+		return nil
+	}
+
+	_, err := h.db.Conn.Exec(ctx, `
+		UPDATE processor_health
+		SET is_falling = FALSE, min_response_time = 0, falling_cycles = 0, is_unknown = TRUE, last_seen_at = NULL, updated_at = NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Conn.Exec(ctx, "TRUNCATE TABLE processor_health_history;")
+	return err
+}
@@ -0,0 +1,172 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const (
+	// a processor is only flipped to unhealthy below the lower bound and back to healthy above the upper one
+	scoreUnhealthyBelow = 0.4
+	scoreHealthyAbove   = 0.7
+
+	passiveWeight   = 0.5
+	passiveAlpha    = 0.1
+	passiveHalfLife = 5 * time.Second
+
+	// latency at which the latency factor drops to one half
+	scoreLatencyReference = 500 * time.Millisecond
+
+	scoreUnknownActive = 0.3
+	scoreStaleActive   = 0.5
+)
+
+// HealthScorer blends what the processors report about themselves with what we observe when forwarding payments to them
+type HealthScorer struct {
+	healthChecker *HealthChecker
+	processors    map[string]*processorScore
+}
+
+type processorScore struct {
+	mu sync.Mutex
+
+	// exponentially weighted moving averages over live forwarding results
+	errorRate   float64
+	timeoutRate float64
+	latency     float64
+	lastSample  time.Time
+
+	healthy bool
+}
+
+func NewHealthScorer(healthChecker *HealthChecker) *HealthScorer {
+	processors := make(map[string]*processorScore, len(service.Processors))
+	for _, processor := range service.Processors {
+		processors[processor] = &processorScore{healthy: true}
+	}
+
+	return &HealthScorer{
+		healthChecker: healthChecker,
+		processors:    processors,
+	}
+}
+
+// RecordForward feeds the passive signals with the outcome of a payment sent to the processor
+func (s *HealthScorer) RecordForward(processor string, elapsed time.Duration, resStatus int, err error) {
+	ps, ok := s.processors[processor]
+	if !ok {
+		return
+	}
+
+	var isError, isTimeout float64
+	if isTimeoutErr(err) {
+		isTimeout = 1
+	} else if err != nil || resStatus >= http.StatusInternalServerError {
+		isError = 1
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.lastSample.IsZero() {
+		ps.errorRate, ps.timeoutRate, ps.latency = isError, isTimeout, float64(elapsed.Milliseconds())
+	} else {
+		ps.errorRate += passiveAlpha * (isError - ps.errorRate)
+		ps.timeoutRate += passiveAlpha * (isTimeout - ps.timeoutRate)
+		ps.latency += passiveAlpha * (float64(elapsed.Milliseconds()) - ps.latency)
+	}
+
+	ps.lastSample = time.Now()
+}
+
+// Score is a value between 0 (unusable) and 1 (healthy and fast)
+func (s *HealthScorer) Score(processor string) float64 {
+	ps, ok := s.processors[processor]
+	if !ok {
+		return 0
+	}
+
+	active := s.activeScore(processor)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return ps.blend(active)
+}
+
+// IsHealthy applies hysteresis over the score, so single blips don't flap the processor in and out of use
+func (s *HealthScorer) IsHealthy(processor string) bool {
+	ps, ok := s.processors[processor]
+	if !ok {
+		return false
+	}
+
+	active := s.activeScore(processor)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	score := ps.blend(active)
+
+	if ps.healthy && score < scoreUnhealthyBelow {
+		ps.healthy = false
+		slog.Warn("processor marked unhealthy", "processor", processor, "score", score)
+	} else if !ps.healthy && score > scoreHealthyAbove {
+		ps.healthy = true
+		slog.Info("processor marked healthy", "processor", processor, "score", score)
+	}
+
+	return ps.healthy
+}
+
+func (s *HealthScorer) activeScore(processor string) float64 {
+	health := s.healthChecker.GetProcessorHealth(processor)
+
+	switch {
+	case health == nil:
+		return scoreStaleActive
+	case health.Failing:
+		return 0
+	case health.Unknown:
+		return scoreUnknownActive
+	default:
+		return latencyFactor(float64(health.MinResponseTime))
+	}
+}
+
+// blend weighs passive signals by how recent they are, so a processor we stopped sending payments to converges back to its probed health
+func (ps *processorScore) blend(active float64) float64 {
+	if ps.lastSample.IsZero() {
+		return active
+	}
+
+	passive := (1 - ps.errorRate) * (1 - ps.timeoutRate) * latencyFactor(ps.latency)
+	weight := passiveWeight * math.Exp2(-float64(time.Since(ps.lastSample))/float64(passiveHalfLife))
+
+	return (1-weight)*active + weight*passive
+}
+
+func latencyFactor(ms float64) float64 {
+	return 1 / (1 + ms/float64(scoreLatencyReference.Milliseconds()))
+}
+
+func isTimeoutErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
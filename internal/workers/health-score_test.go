@@ -0,0 +1,68 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+// probed is a fresh probe result, its active score is 1 at 0ms, 0.5 at 500ms and 1/3 at 1000ms
+func probed(minResponseTime int64) Health {
+	return Health{MinResponseTime: minResponseTime, UpdatedAt: time.Now()}
+}
+
+func TestHealthScorerHysteresis(t *testing.T) {
+	type step struct {
+		health Health
+		want   bool
+	}
+
+	failing := Health{Failing: true, UpdatedAt: time.Now()}
+	unknown := Health{Unknown: true, UpdatedAt: time.Now()}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"starts healthy", []step{{probed(0), true}}},
+		{"stays healthy between the bounds", []step{{probed(500), true}}},
+		{"stays healthy on a stale probe", []step{{Health{}, true}}},
+		{"unhealthy below the lower bound", []step{{probed(1000), false}}},
+		{"unhealthy when failing", []step{{failing, false}}},
+		{"unhealthy when unknown", []step{{unknown, false}}},
+		{"stays unhealthy between the bounds", []step{{failing, false}, {probed(500), false}}},
+		{"healthy again above the upper bound", []step{{failing, false}, {probed(500), false}, {probed(0), true}}},
+		{"a blip between the bounds doesn't flap", []step{{probed(0), true}, {probed(500), true}, {probed(0), true}, {probed(500), true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthChecker := NewHealthChecker(nil, nil, nil)
+			scorer := NewHealthScorer(healthChecker)
+
+			for i, step := range tt.steps {
+				healthChecker.processors[service.ProcessorDefault].set(step.health)
+
+				if got := scorer.IsHealthy(service.ProcessorDefault); got != step.want {
+					t.Errorf("step %d: healthy = %v at score %.2f, want %v", i, got, scorer.Score(service.ProcessorDefault), step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthScorerPassiveSignals(t *testing.T) {
+	healthChecker := NewHealthChecker(nil, nil, nil)
+	healthChecker.processors[service.ProcessorDefault].set(probed(0))
+	scorer := NewHealthScorer(healthChecker)
+
+	// a processor answering every payment with an error drags a perfect probe down to the passive weight
+	for range 10 {
+		scorer.RecordForward(service.ProcessorDefault, 0, 500, nil)
+	}
+
+	if score := scorer.Score(service.ProcessorDefault); score > 1-passiveWeight+0.01 {
+		t.Errorf("score %.2f after failed forwards, want at most %.2f", score, 1-passiveWeight)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,131 +16,248 @@ import (
 )
 
 const (
-	HealthCheckInterval = 5500 * time.Millisecond
+	HealthCheckInterval     = 5500 * time.Millisecond
+	healthStoreSyncInterval = 1 * time.Second
+	healthStaleAfter        = 2 * HealthCheckInterval
 )
 
 type HealthChecker struct {
 	procService *service.ProcessorService
 	db          *database.Db
+	elector     *LeaderElector
 
-	defaultIsFailing     atomic.Bool
-	defaultMinRespTime   atomic.Int64
-	defaultFallingCycles atomic.Int64
+	processors map[string]*processorHealth
 
-	fallbackIsFailing     atomic.Bool
-	fallbackMinRespTime   atomic.Int64
-	fallbackFallingCycles atomic.Int64
+	subs   map[chan HealthEvent]struct{}
+	subsMu sync.Mutex
+
+	historyPrunedAt atomic.Int64
 }
 
+// Health keeps the last known good Failing and MinResponseTime, Unknown is set while the processor can't be reached
 type Health struct {
 	Failing         bool  `json:"failing"`
 	MinResponseTime int64 `json:"minResponseTime"`
 	FallingCycles   int64
+	Unknown         bool
+	LastSeenAt      time.Time
+	UpdatedAt       time.Time
+}
+
+type processorHealth struct {
+	mu     sync.RWMutex
+	health Health
 }
 
 func (h *Health) String() string {
-	return fmt.Sprintf("Failing: %v| MinResponseTime: %v| FallingCycles: %v", h.Failing, h.MinResponseTime, h.FallingCycles)
+	return fmt.Sprintf("Failing: %v| MinResponseTime: %v| FallingCycles: %v| Unknown: %v| LastSeenAt: %v| UpdatedAt: %v", h.Failing, h.MinResponseTime, h.FallingCycles, h.Unknown, h.LastSeenAt, h.UpdatedAt)
 }
 
-func NewHealthChecker(db *database.Db, procService *service.ProcessorService) *HealthChecker {
+func (h *Health) IsStale() bool {
+	return h.UpdatedAt.IsZero() || time.Since(h.UpdatedAt) > healthStaleAfter
+}
+
+func (h *Health) IsAvailable() bool {
+	return !h.Failing && !h.Unknown
+}
+
+func NewHealthChecker(db *database.Db, procService *service.ProcessorService, elector *LeaderElector) *HealthChecker {
+	processors := make(map[string]*processorHealth, len(service.Processors))
+	for _, processor := range service.Processors {
+		processors[processor] = &processorHealth{health: Health{Unknown: true}}
+	}
 
 	return &HealthChecker{
 		procService: procService,
 		db:          db,
+		elector:     elector,
+		processors:  processors,
+		subs:        make(map[chan HealthEvent]struct{}),
 	}
 }
 
 func (h *HealthChecker) StartHealthChecker() {
-	ticker := time.NewTicker(HealthCheckInterval)
-	go func() {
-		for range ticker.C {
-			defaultHealth, fallbackHealth := h.checkProcessors()
-			h.updateHealthStore(service.ProcessorDefault, defaultHealth)
-			h.updateHealthStore(service.ProcessorFallback, fallbackHealth)
+	for _, processor := range service.Processors {
+		h.startProber(processor)
+	}
 
-			slog.Info("default processor health", "health", defaultHealth)
-			slog.Info("fallback processor health", "health", fallbackHealth)
-		}
-	}()
+	h.startStoreSync()
 }
 
+// GetProcessorsHealth returns nil for a processor whose health was not refreshed recently, either by this node or by the leader through the store
 func (h *HealthChecker) GetProcessorsHealth() (*Health, *Health) {
-	return h.buildDefaultProcessorHealth(), h.buildFallbackProcessorHealth()
+	return h.GetProcessorHealth(service.ProcessorDefault), h.GetProcessorHealth(service.ProcessorFallback)
 }
 
-func (h *HealthChecker) checkProcessors() (*Health, *Health) {
-	defaultProcessorHealth, err := h.callHealthCheckEndpoint(service.ProcessorDefault)
-	if err != nil {
-		return nil, nil
+func (h *HealthChecker) GetProcessorHealth(processor string) *Health {
+	health := h.processors[processor].get()
+	if health.IsStale() {
+		return nil
 	}
 
-	h.defaultIsFailing.Store(defaultProcessorHealth.Failing)
-	h.defaultMinRespTime.Store(defaultProcessorHealth.MinResponseTime)
+	return &health
+}
 
-	if defaultProcessorHealth.Failing {
-		h.defaultFallingCycles.Add(1)
-	} else {
-		h.defaultFallingCycles.Store(0)
+// startProber probes a single processor on its own schedule, so one processor being down or rate limiting us never delays the other
+func (h *HealthChecker) startProber(processor string) {
+	go func() {
+		for {
+			if !h.elector.IsLeader() {
+				time.Sleep(healthStoreSyncInterval)
+				continue
+			}
+
+			time.Sleep(h.probe(processor))
+		}
+	}()
+}
+
+// probe returns how long to wait before probing the processor again
+func (h *HealthChecker) probe(processor string) time.Duration {
+	ph := h.processors[processor]
+
+	probeRes, retryAfter, err := h.callHealthCheckEndpoint(processor)
+	if retryAfter > 0 {
+		slog.Warn("processor health check rate limited", "processor", processor, "retryAfter", retryAfter)
+		h.recordProbe(processor, nil, errHealthCheckRateLimited)
+		return retryAfter
 	}
 
-	fallbackProcessorHealth, err := h.callHealthCheckEndpoint(service.ProcessorFallback)
+	var prev, health Health
 	if err != nil {
-		return nil, nil
+		prev, health = ph.markUnknown()
+	} else {
+		prev, health = ph.markSeen(probeRes)
 	}
 
-	h.fallbackIsFailing.Store(fallbackProcessorHealth.Failing)
-	h.fallbackMinRespTime.Store(fallbackProcessorHealth.MinResponseTime)
+	h.publishTransitions(processor, prev, health)
+	h.updateHealthStore(processor, &health)
+	h.recordProbe(processor, probeRes, err)
+	slog.Info("processor health", "processor", processor, "health", &health)
 
-	if fallbackProcessorHealth.Failing {
-		h.fallbackFallingCycles.Add(1)
-	} else {
-		h.fallbackFallingCycles.Store(0)
-	}
+	return HealthCheckInterval
+}
 
-	return h.buildDefaultProcessorHealth(), h.buildFallbackProcessorHealth()
+// startStoreSync keeps followers on the same view as the leader by reading what it last wrote to processor_health
+func (h *HealthChecker) startStoreSync() {
+	ticker := time.NewTicker(healthStoreSyncInterval)
+	go func() {
+		for range ticker.C {
+			if h.elector.IsLeader() {
+				continue
+			}
+
+			for processor, health := range h.getHealthFromStore() {
+				ph, ok := h.processors[processor]
+				if !ok {
+					continue
+				}
+
+				if health.IsStale() {
+					slog.Warn("stale processor health in store", "processor", processor, "health", health)
+				}
+
+				prev := ph.set(*health)
+				h.publishTransitions(processor, prev, *health)
+			}
+		}
+	}()
 }
 
-func (h *HealthChecker) callHealthCheckEndpoint(processor string) (*Health, error) {
+// callHealthCheckEndpoint returns a positive retry delay when the processor rate limited the probe
+func (h *HealthChecker) callHealthCheckEndpoint(processor string) (*Health, time.Duration, error) {
 	var healthCheckRes Health
 
-	resBody, resStatus, err := h.procService.MakeRequest(processor, http.MethodGet, "/payments/service-health", nil, 0)
-	if err != nil || resStatus > 399 {
-		slog.Error("Error health checking processor", "err", err, "resStatus", resStatus)
-		return nil, err
+	res, err := h.procService.MakeBufferedRequest(processor, http.MethodGet, "/payments/service-health", nil, 0)
+	if err != nil {
+		slog.Error("Error health checking processor", "processor", processor, "err", err)
+		return nil, 0, err
 	}
 
-	json.NewDecoder(resBody).Decode(&healthCheckRes)
+	if res.Status == http.StatusTooManyRequests {
+		return nil, parseRetryAfter(res.Header.Get("Retry-After")), nil
+	}
 
-	return &healthCheckRes, nil
-}
+	if res.Status > 399 {
+		slog.Error("Error health checking processor", "processor", processor, "resStatus", res.Status)
+		return nil, 0, fmt.Errorf("health check returned status %d", res.Status)
+	}
 
-func (h *HealthChecker) buildDefaultProcessorHealth() *Health {
-	return &Health{
-		Failing:         h.defaultIsFailing.Load(),
-		MinResponseTime: h.defaultMinRespTime.Load(),
-		FallingCycles:   h.defaultFallingCycles.Load(),
+	err = json.Unmarshal(res.Body, &healthCheckRes)
+	if err != nil {
+		slog.Error("Error decoding processor health", "processor", processor, "err", err)
+		return nil, 0, err
 	}
+
+	return &healthCheckRes, 0, nil
 }
 
-func (h *HealthChecker) buildFallbackProcessorHealth() *Health {
-	return &Health{
-		Failing:         h.fallbackIsFailing.Load(),
-		MinResponseTime: h.fallbackMinRespTime.Load(),
-		FallingCycles:   h.fallbackFallingCycles.Load(),
+func (ph *processorHealth) get() Health {
+	ph.mu.RLock()
+	defer ph.mu.RUnlock()
+
+	return ph.health
+}
+
+func (ph *processorHealth) set(health Health) Health {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	prev := ph.health
+	ph.health = health
+
+	return prev
+}
+
+func (ph *processorHealth) markSeen(probeRes *Health) (Health, Health) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	prev := ph.health
+	now := time.Now()
+
+	if probeRes.Failing {
+		ph.health.FallingCycles++
+	} else {
+		ph.health.FallingCycles = 0
 	}
+
+	ph.health.Failing = probeRes.Failing
+	ph.health.MinResponseTime = probeRes.MinResponseTime
+	ph.health.Unknown = false
+	ph.health.LastSeenAt = now
+	ph.health.UpdatedAt = now
+
+	return prev, ph.health
+}
+
+func (ph *processorHealth) markUnknown() (Health, Health) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	prev := ph.health
+	ph.health.Unknown = true
+	ph.health.UpdatedAt = time.Now()
+
+	return prev, ph.health
 }
 
 func (h *HealthChecker) updateHealthStore(processor string, health *Health) {
-	if health == nil {
+	if health == nil || h.db == nil {
 		return
 	}
 
+	var lastSeenAt *time.Time
+	if !health.LastSeenAt.IsZero() {
+		lastSeenAt = &health.LastSeenAt
+	}
+
 	_, err := h.db.Conn.Exec(context.TODO(), `
 		UPDATE processor_health
-		SET is_falling = $1, min_response_time = $2, falling_cycles = $3
-		WHERE processor = $4
+		SET is_falling = $1, min_response_time = $2, falling_cycles = $3, is_unknown = $4, last_seen_at = $5, updated_at = $6
+		WHERE processor = $7
 	`,
-		health.Failing, health.MinResponseTime, health.FallingCycles, processor,
+		health.Failing, health.MinResponseTime, health.FallingCycles, health.Unknown, lastSeenAt, health.UpdatedAt, processor,
 	)
 	if err != nil {
 		slog.Error("Error updating health in store:", "err", err)
@@ -146,33 +265,68 @@ func (h *HealthChecker) updateHealthStore(processor string, health *Health) {
 	}
 }
 
-func (h *HealthChecker) getHealthFromStore() (*Health, *Health) {
-	var defaultHealth, fallbackHealth Health
+func (h *HealthChecker) getHealthFromStore() map[string]*Health {
+	if h.db == nil {
+		return nil
+	}
 
-	res, err := h.db.Conn.Query(context.TODO(), "SELECT * FROM processor_health LIMIT 2")
+	res, err := h.db.Conn.Query(context.TODO(), `
+		SELECT processor, is_falling, min_response_time, falling_cycles, is_unknown, last_seen_at, updated_at
+		FROM processor_health
+	`)
 	if err != nil {
 		slog.Error("Error getting health from store:", "err", err)
-		return nil, nil
+		return nil
 	}
+	defer res.Close()
+
+	healths := make(map[string]*Health, len(service.Processors))
 
 	var processor string
 	var failing bool
 	var minResponseTime int64
 	var fallingCycles int64
+	var unknown bool
+	var lastSeenAt *time.Time
+	var updatedAt *time.Time
 
 	for res.Next() {
-		err = res.Scan(&processor, &failing, &minResponseTime, &fallingCycles)
+		err = res.Scan(&processor, &failing, &minResponseTime, &fallingCycles, &unknown, &lastSeenAt, &updatedAt)
 		if err != nil {
 			slog.Error(fmt.Sprintf("Error scanning data: %v", err))
-			return nil, nil
+			return nil
+		}
+
+		health := &Health{
+			Failing:         failing,
+			MinResponseTime: minResponseTime,
+			FallingCycles:   fallingCycles,
+			Unknown:         unknown,
 		}
 
-		if processor == service.ProcessorDefault {
-			defaultHealth = Health{failing, minResponseTime, fallingCycles}
-		} else {
-			fallbackHealth = Health{failing, minResponseTime, fallingCycles}
+		if lastSeenAt != nil {
+			health.LastSeenAt = *lastSeenAt
 		}
+
+		if updatedAt != nil {
+			health.UpdatedAt = *updatedAt
+		}
+
+		healths[processor] = health
+	}
+
+	return healths
+}
+
+// parseRetryAfter accepts both forms allowed for the header, falling back to the regular interval when it is missing or invalid
+func parseRetryAfter(v string) time.Duration {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
 	}
 
-	return &defaultHealth, &fallbackHealth
+	return HealthCheckInterval
 }
@@ -0,0 +1,143 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+)
+
+const (
+	healthCheckerLockID = 2025
+	leaderQueryTimeout  = 1 * time.Second
+)
+
+type LeaderElector struct {
+	db   *database.Db
+	node string
+
+	// advisory locks belong to the session, so the leader keeps the connection that took it out of the pool
+	conn     *pgxpool.Conn
+	isLeader atomic.Bool
+	mu       sync.Mutex
+}
+
+func NewLeaderElector(db *database.Db, node string) *LeaderElector {
+	return &LeaderElector{
+		db:   db,
+		node: node,
+	}
+}
+
+// StartLeaderElection makes this node the leader right away when there is no database to campaign on
+func (l *LeaderElector) StartLeaderElection() {
+	if l.db == nil {
+		l.isLeader.Store(true)
+		return
+	}
+
+	l.campaign()
+
+	ticker := time.NewTicker(HealthCheckInterval)
+	go func() {
+		for range ticker.C {
+			l.campaign()
+		}
+	}()
+}
+
+func (l *LeaderElector) IsLeader() bool {
+	return l.isLeader.Load()
+}
+
+func (l *LeaderElector) Node() string {
+	return l.node
+}
+
+// Leader returns the application_name of the session currently holding the lock, or an empty string if nobody does
+func (l *LeaderElector) Leader(ctx context.Context) (string, error) {
+	if l.IsLeader() {
+		return l.node, nil
+	}
+
+	var leader string
+	err := l.db.Conn.QueryRow(ctx, `
+		SELECT a.application_name
+		FROM pg_locks lk
+		JOIN pg_stat_activity a ON a.pid = lk.pid
+		WHERE lk.locktype = 'advisory' AND lk.granted AND lk.classid = 0 AND lk.objid::bigint = $1 AND lk.objsubid = 1
+	`, healthCheckerLockID).Scan(&leader)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	return leader, err
+}
+
+func (l *LeaderElector) Resign() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaderQueryTimeout)
+	defer cancel()
+
+	l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", healthCheckerLockID)
+	l.dropConn(ctx)
+	slog.Info("resigned health checker leadership", "node", l.node)
+}
+
+func (l *LeaderElector) campaign() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaderQueryTimeout)
+	defer cancel()
+
+	if l.conn != nil {
+		err := l.conn.Ping(ctx)
+		if err == nil {
+			return
+		}
+
+		slog.Error("Lost health checker leadership", "node", l.node, "err", err)
+		l.dropConn(ctx)
+	}
+
+	conn, err := l.db.Conn.Acquire(ctx)
+	if err != nil {
+		slog.Error("Error acquiring connection for leader election", "err", err)
+		return
+	}
+
+	var acquired bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", healthCheckerLockID).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil {
+			slog.Error("Error trying health checker lock", "err", err)
+		}
+
+		conn.Release()
+		return
+	}
+
+	l.conn = conn
+	l.isLeader.Store(true)
+	slog.Info("acquired health checker leadership", "node", l.node)
+}
+
+// dropConn closes the leader connection instead of returning it to the pool, so a lock we may still hold dies with it
+func (l *LeaderElector) dropConn(ctx context.Context) {
+	l.isLeader.Store(false)
+	l.conn.Hijack().Close(ctx)
+	l.conn = nil
+}
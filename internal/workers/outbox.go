@@ -0,0 +1,202 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const (
+	outboxPollInterval = 100 * time.Millisecond
+	outboxBatchSize    = 50
+	outboxConcurrency  = 8
+	// must outlast a forwarding attempt, or the intent would be claimed again while still being delivered
+	outboxLease = 30 * time.Second
+
+	outboxRetryDelay    = 1 * time.Second
+	outboxMaxRetryDelay = 30 * time.Second
+	outboxMaxAttempts   = maxRetriesBeforePark
+
+	outboxRetention     = 1 * time.Hour
+	outboxPruneInterval = 1 * time.Minute
+)
+
+// OutboxDispatcher delivers the forwarding intents written by the create handler, leased to one dispatcher at a time
+type OutboxDispatcher struct {
+	paymentRep  *repositories.PaymentRepository
+	procService *service.ProcessorService
+	scorer      *HealthScorer
+	// nil unless summaries are aggregated in memory, deliveries are counted there as they never go through the payment store
+	aggregates *repositories.PaymentAggregates
+
+	prunedAt time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewOutboxDispatcher(paymentRep *repositories.PaymentRepository, procService *service.ProcessorService, scorer *HealthScorer, aggregates *repositories.PaymentAggregates) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		paymentRep:  paymentRep,
+		procService: procService,
+		scorer:      scorer,
+		aggregates:  aggregates,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (od *OutboxDispatcher) StartOutboxDispatcher() {
+	go func() {
+		defer close(od.done)
+
+		for {
+			// a full batch means there is likely more waiting, so only an incomplete one waits for the next poll
+			wait := outboxPollInterval
+			if od.dispatchBatch() == outboxBatchSize {
+				wait = 0
+			}
+
+			select {
+			case <-od.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop waits for the batch in progress to be delivered and stops claiming intents
+func (od *OutboxDispatcher) Stop() {
+	close(od.stop)
+	<-od.done
+}
+
+func (od *OutboxDispatcher) dispatchBatch() int {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxLease)
+	defer cancel()
+
+	od.prune(ctx)
+
+	intents, err := od.paymentRep.ClaimOutbox(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		slog.Error("Error claiming outbox intents", "err", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, outboxConcurrency)
+
+	for i := range intents {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(intent *entities.OutboxIntent) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			od.deliver(ctx, intent)
+		}(&intents[i])
+	}
+
+	wg.Wait()
+	return len(intents)
+}
+
+func (od *OutboxDispatcher) deliver(ctx context.Context, intent *entities.OutboxIntent) {
+	logger := slog.With("correlationId", intent.P.CorrelationId, "attempts", intent.Attempts)
+
+	processor := od.pickProcessor()
+	if processor == "" {
+		od.retry(ctx, intent, FailureProcUnhealthy)
+		return
+	}
+
+	paymentJSONBytes, _ := json.Marshal(intent.P)
+	start := time.Now()
+	res, err := od.procService.MakeBufferedRequest(processor, http.MethodPost, "/payments", bytes.NewReader(paymentJSONBytes), 0)
+
+	var resStatus int
+	if res != nil {
+		resStatus = res.Status
+	}
+	od.scorer.RecordForward(processor, time.Since(start), resStatus, err)
+
+	// a 422 means the processor already has the payment, from a delivery that was not marked done before its dispatcher died
+	if err == nil && (resStatus < 400 || resStatus == http.StatusUnprocessableEntity) {
+		if od.aggregates != nil {
+			od.aggregates.Record(intent.P, processor)
+		}
+
+		err = od.paymentRep.CompleteOutbox(ctx, intent, processor)
+		if err != nil {
+			logger.Error("Error completing outbox intent, it will be delivered again", "processor", processor, "err", err)
+		}
+
+		return
+	}
+
+	reason := FailureProcTimeout
+	if err != nil && !isTimeoutErr(err) {
+		reason = FailureProcError
+	} else if err == nil {
+		reason = fmt.Sprintf("%s: status %d", FailureProcError, resStatus)
+	}
+
+	logger.Warn("Error forwarding outbox intent", "processor", processor, "reason", reason, "err", err)
+	od.retry(ctx, intent, reason)
+}
+
+func (od *OutboxDispatcher) pickProcessor() string {
+	switch {
+	case od.scorer.IsHealthy(service.ProcessorDefault):
+		return service.ProcessorDefault
+	case od.scorer.IsHealthy(service.ProcessorFallback):
+		return service.ProcessorFallback
+	default:
+		return ""
+	}
+}
+
+// retry backs off linearly with the attempts, and parks the payment once they run out
+func (od *OutboxDispatcher) retry(ctx context.Context, intent *entities.OutboxIntent, reason string) {
+	var err error
+
+	if intent.Attempts >= outboxMaxAttempts {
+		slog.Warn("parking payment after too many forwarding attempts", "correlationId", intent.P.CorrelationId, "attempts", intent.Attempts)
+		err = od.paymentRep.ParkOutbox(ctx, intent)
+	} else {
+		err = od.paymentRep.RetryOutbox(ctx, intent, min(time.Duration(intent.Attempts)*outboxRetryDelay, outboxMaxRetryDelay), reason)
+	}
+
+	// a failed reschedule is retried once the lease ends
+	if err != nil {
+		slog.Error("Error rescheduling outbox intent", "correlationId", intent.P.CorrelationId, "err", err)
+	}
+}
+
+func (od *OutboxDispatcher) prune(ctx context.Context) {
+	if time.Since(od.prunedAt) < outboxPruneInterval {
+		return
+	}
+	od.prunedAt = time.Now()
+
+	pruned, err := od.paymentRep.PruneOutbox(ctx, time.Now().Add(-outboxRetention))
+	if err != nil {
+		slog.Error("Error pruning outbox", "err", err)
+		return
+	}
+
+	if pruned > 0 {
+		slog.Info("pruned outbox", "intents", pruned)
+	}
+}
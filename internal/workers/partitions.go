@@ -0,0 +1,191 @@
+package workers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/config"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+)
+
+const (
+	partitionsInterval = 1 * time.Hour
+	partitionsTimeout  = 10 * time.Minute
+	// days ahead of today that always have a partition, so payments never land in the default partition
+	partitionsAhead = 3
+)
+
+// PartitionManager keeps daily partitions of payments ahead of time, and detaches then archives the ones past retention
+type PartitionManager struct {
+	paymentRep *repositories.PaymentRepository
+	elector    *LeaderElector
+
+	retention  time.Duration
+	archiveDir string
+}
+
+type archivedPayment struct {
+	Id            int64     `json:"id"`
+	CorrelationId string    `json:"correlationId"`
+	Processor     *string   `json:"processor"`
+	Status        string    `json:"status"`
+	Amount        float64   `json:"amount"`
+	RequestedAt   time.Time `json:"requestedAt"`
+}
+
+func NewPartitionManager(cfg *config.Config, paymentRep *repositories.PaymentRepository, elector *LeaderElector) *PartitionManager {
+	return &PartitionManager{
+		paymentRep: paymentRep,
+		elector:    elector,
+		retention:  cfg.PaymentsRetention,
+		archiveDir: cfg.PaymentsArchiveDir,
+	}
+}
+
+// StartPartitionManager keeps daily partitions ahead and archives those past retention, when set
+func (pm *PartitionManager) StartPartitionManager() {
+	ticker := time.NewTicker(partitionsInterval)
+	go func() {
+		for {
+			if pm.elector.IsLeader() {
+				pm.run()
+			}
+
+			<-ticker.C
+		}
+	}()
+}
+
+func (pm *PartitionManager) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), partitionsTimeout)
+	defer cancel()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	err := pm.paymentRep.CreatePartitions(ctx, today, partitionsAhead+1)
+	if err != nil {
+		slog.Error("Error creating payment partitions", "err", err)
+	}
+
+	// partitions detached by a run that failed or died before archiving them
+	pending, err := pm.paymentRep.PendingArchives(ctx)
+	if err != nil {
+		slog.Error("Error listing pending payment archives", "err", err)
+		return
+	}
+
+	for _, partition := range pending {
+		err = pm.complete(ctx, partition)
+		if err != nil {
+			slog.Error("Error archiving detached payment partition", "partition", partition.Name, "err", err)
+			return
+		}
+	}
+
+	if pm.retention <= 0 {
+		return
+	}
+
+	partitions, err := pm.paymentRep.Partitions(ctx)
+	if err != nil {
+		slog.Error("Error listing payment partitions", "err", err)
+		return
+	}
+
+	expiredBefore := today.Add(-pm.retention)
+	for _, partition := range partitions {
+		if partition.To.After(expiredBefore) {
+			break
+		}
+
+		err = pm.archive(ctx, partition)
+		if err != nil {
+			slog.Error("Error archiving payment partition", "partition", partition.Name, "err", err)
+			return
+		}
+	}
+}
+
+// archive detaches the partition before writing its payments, so none is written to it while it is archived
+func (pm *PartitionManager) archive(ctx context.Context, partition entities.PaymentPartition) error {
+	err := pm.paymentRep.DetachPartition(ctx, partition)
+	if err != nil {
+		return fmt.Errorf("detaching partition: %w", err)
+	}
+
+	return pm.complete(ctx, partition)
+}
+
+// complete archives a detached partition to a gzipped NDJSON file, renamed only once whole, then drops it
+func (pm *PartitionManager) complete(ctx context.Context, partition entities.PaymentPartition) error {
+	err := os.MkdirAll(pm.archiveDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(pm.archiveDir, partition.Name+".ndjson.gz")
+	total, err := pm.writeArchive(ctx, partition, file)
+	if err != nil {
+		return err
+	}
+
+	err = pm.paymentRep.CompleteArchive(ctx, partition, file, total)
+	if err != nil {
+		return fmt.Errorf("dropping partition: %w", err)
+	}
+
+	slog.Info("archived payment partition", "partition", partition.Name, "file", file, "payments", total)
+	return nil
+}
+
+func (pm *PartitionManager) writeArchive(ctx context.Context, partition entities.PaymentPartition, file string) (int64, error) {
+	tmp, err := os.CreateTemp(pm.archiveDir, partition.Name+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(gz)
+
+	var total int64
+	err = pm.paymentRep.ExportPartition(ctx, partition, func(record *entities.PaymentRecord) error {
+		total++
+		return enc.Encode(&archivedPayment{
+			Id:            record.Id,
+			CorrelationId: record.CorrelationId,
+			Processor:     record.Processor,
+			Status:        record.Status,
+			Amount:        record.Amount(),
+			RequestedAt:   record.RequestedAt,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return total, os.Rename(tmp.Name(), file)
+}
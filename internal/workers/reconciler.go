@@ -0,0 +1,154 @@
+package workers
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/config"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/database"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const (
+	// payments younger than this may still be in flight, so they are left out of the reconciled window
+	reconcileLag     = 10 * time.Second
+	reconcileTimeout = 30 * time.Second
+)
+
+var (
+	reconciliationDrift = expvar.NewMap("reconciliation_drift")
+	reconciliationRuns  = expvar.NewInt("reconciliation_runs")
+)
+
+type Reconciler struct {
+	reconService *service.ReconciliationService
+	db           *database.Db
+	elector      *LeaderElector
+
+	interval       time.Duration
+	window         time.Duration
+	driftThreshold int64
+	autoRepair     bool
+}
+
+func NewReconciler(cfg *config.Config, db *database.Db, reconService *service.ReconciliationService, elector *LeaderElector) *Reconciler {
+	return &Reconciler{
+		reconService:   reconService,
+		db:             db,
+		elector:        elector,
+		interval:       cfg.ReconcileInterval,
+		window:         cfg.ReconcileWindow,
+		driftThreshold: cfg.ReconcileDriftThreshold,
+		autoRepair:     cfg.ReconcileAutoRepair,
+	}
+}
+
+// StartReconciler reconciles the last window of payments every interval
+func (rc *Reconciler) StartReconciler() {
+	if rc.interval <= 0 || rc.window <= 0 {
+		slog.Info("scheduled reconciliation disabled")
+		return
+	}
+
+	ticker := time.NewTicker(rc.interval)
+	go func() {
+		for range ticker.C {
+			if !rc.elector.IsLeader() {
+				continue
+			}
+
+			rc.run()
+		}
+	}()
+}
+
+func (rc *Reconciler) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	to := time.Now().UTC().Add(-reconcileLag).Truncate(time.Millisecond)
+	from := to.Add(-rc.window)
+	tr := entities.TimeRange{From: &from, To: &to}
+
+	var repaired map[string]int64
+	if rc.autoRepair {
+		var err error
+
+		repaired, err = rc.reconService.Repair(ctx, tr)
+		if err != nil {
+			slog.Error("Error repairing pending payments", "err", err)
+		}
+	}
+
+	report, err := rc.reconService.Reconcile(ctx, tr)
+	if err != nil {
+		slog.Error("Error running scheduled reconciliation", "err", err)
+		return
+	}
+
+	reconciliationRuns.Add(1)
+
+	for _, rec := range report.Processors {
+		rc.saveRun(ctx, tr, &rec, repaired[rec.Processor])
+
+		if rec.Error != nil {
+			slog.Error("Error reconciling processor", "processor", rec.Processor, "err", rec.Error)
+			continue
+		}
+
+		requestsDelta := rec.RequestsDelta()
+		if abs(requestsDelta) <= rc.driftThreshold {
+			continue
+		}
+
+		reconciliationDrift.Add(rec.Processor, 1)
+		slog.Warn("reconciliation drift",
+			"event", "reconciliation_drift",
+			"processor", rec.Processor,
+			"from", from,
+			"to", to,
+			"requestsDelta", requestsDelta,
+			"amountDeltaCents", rec.AmountDeltaCents(),
+			"suspectedMissing", rec.SuspectedMissing,
+		)
+	}
+}
+
+func (rc *Reconciler) saveRun(ctx context.Context, tr entities.TimeRange, rec *service.ProcessorReconciliation, repaired int64) {
+	if rc.db == nil {
+		return
+	}
+
+	var remoteRequests, remoteAmount *int64
+	var errMsg *string
+
+	if rec.Remote != nil {
+		remoteRequests, remoteAmount = &rec.Remote.TotalRequests, &rec.Remote.AmountCents
+	}
+
+	if rec.Error != nil {
+		msg := rec.Error.Error()
+		errMsg = &msg
+	}
+
+	_, err := rc.db.Conn.Exec(ctx, `
+		INSERT INTO reconciliation_runs (processor, range_from, range_to, local_requests, local_amount, remote_requests, remote_amount, error, repaired)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
+		rec.Processor, *tr.From, *tr.To, rec.Local.TotalRequests, rec.Local.AmountCents, remoteRequests, remoteAmount, errMsg, repaired,
+	)
+	if err != nil {
+		slog.Error("Error saving reconciliation run:", "err", err)
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/entities"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/infra/wal"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/repositories"
+	"github.com/lucashmsilva/rinha-2025-api-go/internal/service"
+)
+
+const (
+	walReplayTimeout = 5 * time.Second
+	// in milliseconds, as taken by the processor service
+	walReplayLookupTimeout = 2000
+)
+
+// ReplayWAL hands the payments accepted but not settled before the last shutdown back to the forwarding pipeline
+func ReplayWAL(acceptedLog *wal.WAL, paymentStore repositories.PaymentStore, procService *service.ProcessorService, dlq *DLQ, outbox repositories.PaymentOutbox) {
+	entries := acceptedLog.Recovered()
+	if len(entries) == 0 {
+		return
+	}
+
+	// once the store or the processors can't be reached, say the database is down, every lookup would wait for its timeout just to fail
+	lookup, processorLookup := true, true
+	replayed := 0
+
+	for _, entry := range entries {
+		var payment entities.Payment
+
+		err := json.Unmarshal(entry.Data, &payment)
+		if err != nil {
+			slog.Error("Error decoding wal entry, dropping it", "seq", entry.Seq, "err", err)
+			ackEntry(acceptedLog, entry.Seq)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), walReplayTimeout)
+
+		if lookup {
+			record, err := paymentStore.Get(ctx, payment.CorrelationId)
+			if err == nil && record.Status != entities.PaymentStatusPending {
+				cancel()
+				ackEntry(acceptedLog, entry.Seq)
+				continue
+			}
+
+			if err != nil && !errors.Is(err, repositories.ErrPaymentNotFound) {
+				slog.Warn("Error looking up payments replayed from the wal, forwarding them all again", "err", err)
+				lookup = false
+			}
+		}
+
+		var processor string
+		if processorLookup {
+			processor, err = processedBy(procService, payment.CorrelationId)
+			if err != nil {
+				slog.Warn("Error looking up replayed payments in the processors, forwarding them all again", "err", err)
+				processorLookup = false
+			}
+		}
+
+		// left in the wal when the write fails, the next start looks it up again instead of forwarding it twice
+		if processor != "" {
+			seq := entry.Seq
+			err = paymentStore.MarkProcessed(repositories.WithOnDurable(ctx, func() { ackEntry(acceptedLog, seq) }), &payment, processor)
+			cancel()
+			if err != nil {
+				slog.Error("Error marking replayed payment as processed, keeping it in the wal", "correlationId", payment.CorrelationId, "processor", processor, "err", err)
+			}
+
+			continue
+		}
+
+		replayed++
+
+		if outbox != nil {
+			err = outbox.EnqueueForwarding(ctx, &payment)
+			if err == nil {
+				cancel()
+				ackEntry(acceptedLog, entry.Seq)
+				continue
+			}
+
+			slog.Error("Error recording replayed payment in the outbox, sending it to the DLQ", "correlationId", payment.CorrelationId, "err", err)
+		}
+
+		err = paymentStore.CreatePending(ctx, &payment)
+		cancel()
+		if err != nil {
+			slog.Error("Error recording replayed payment", "correlationId", payment.CorrelationId, "err", err)
+		}
+
+		dlq.PushToQueue(&entities.PaymentRetry{P: &payment, WalSeq: entry.Seq})
+	}
+
+	slog.Info("replayed payments from the wal", "entries", len(entries), "forwarded", replayed)
+}
+
+// processedBy returns the processor that has the payment, or an empty string when none has it
+func processedBy(procService *service.ProcessorService, correlationId string) (string, error) {
+	for _, processor := range service.Processors {
+		res, err := procService.MakeBufferedRequest(processor, http.MethodGet, "/payments/"+url.PathEscape(correlationId), nil, walReplayLookupTimeout)
+		if err != nil {
+			return "", err
+		}
+
+		if res.Status == http.StatusOK {
+			return processor, nil
+		}
+	}
+
+	return "", nil
+}
+
+func ackEntry(acceptedLog *wal.WAL, seq uint64) {
+	err := acceptedLog.Ack(seq)
+	if err != nil {
+		slog.Error("Error acknowledging wal entry", "seq", seq, "err", err)
+	}
+}
@@ -6,9 +6,9 @@ if [ "$BUILD_WITH_RACE_DETECTION" = "1" ];
 then
   echo "building with race detection..."
   CGO_ENABLED=1
-  go build -race -o /usr/local/bin/main ../cmd/api/main.go
+  go build -race -o /usr/local/bin/main ../cmd/api
 else
-  go build -o /usr/local/bin/main ../cmd/api/main.go
+  go build -o /usr/local/bin/main ../cmd/api
 fi
 
 echo "finished build"